@@ -0,0 +1,203 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupFilename returns the name of the backup file of the database saved
+// under filename for the generation, starting from 1 for the most recent.
+func backupFilename(filename string, generation int) string {
+	return filename + "." + strconv.Itoa(generation)
+}
+
+// backupsFilename returns the name of the file that holds names of backups
+// of the database saved under filename when BackupName option is set, one
+// per line, starting from the most recent.
+func backupsFilename(filename string) string {
+	return filename + ".backups"
+}
+
+// backup saves a copy of the database saved under filename as the first
+// generation backup, rotating the existing backups and removing the ones
+// beyond the number of Backups. The database is copied, not renamed, so that
+// it is available until it is replaced by the new one.
+func (u *Updater) backup(fs FileSystem, filename string) error {
+	if u.Backups <= 0 {
+		return nil
+	}
+
+	f, err := fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("open db file: %w", err)
+	}
+	defer f.Close()
+
+	if u.BackupName != nil {
+		return u.backupNamed(fs, filename, f)
+	}
+
+	if err := fs.Remove(backupFilename(filename, u.Backups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove backup: %w", err)
+	}
+	for i := u.Backups - 1; i > 0; i-- {
+		if err := fs.Rename(backupFilename(filename, i), backupFilename(filename, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate backup: %w", err)
+		}
+	}
+	if _, err := writeFileFrom(fs, backupFilename(filename, 1), f, u.fileMode()); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	return nil
+}
+
+// backupNamed saves a copy of the database from the reader under the name
+// returned by BackupName, with the database build time, or the file
+// modification time if the metadata can not be read. Names of backups are
+// kept in the backups file to remove the ones beyond the number of Backups.
+func (u *Updater) backupNamed(fs FileSystem, filename string, r io.Reader) error {
+	var t time.Time
+	if md, err := u.readMetadata(fs, filename); err == nil {
+		t = md.BuildEpoch
+	} else if info, err := fs.Stat(filename); err == nil {
+		t = info.ModTime()
+	}
+	name := u.BackupName(filename, t)
+
+	names, err := u.backupNames(fs, filename)
+	if err != nil {
+		return err
+	}
+	if _, err := writeFileFrom(fs, name, r, u.fileMode()); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+
+	rotated := []string{name}
+	for _, n := range names {
+		if n != name {
+			rotated = append(rotated, n)
+		}
+	}
+	for len(rotated) > u.Backups {
+		if err := fs.Remove(rotated[len(rotated)-1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove backup: %w", err)
+		}
+		rotated = rotated[:len(rotated)-1]
+	}
+
+	if err := writeFile(fs, backupsFilename(filename), []byte(strings.Join(rotated, "\n")+"\n"), u.fileMode()); err != nil {
+		return fmt.Errorf("write backups file: %w", err)
+	}
+	return nil
+}
+
+// backupNames returns names of backups from the backups file, starting from
+// the most recent.
+func (u *Updater) backupNames(fs FileSystem, filename string) ([]string, error) {
+	data, err := readFile(fs, backupsFilename(filename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backups file: %w", err)
+	}
+	var names []string
+	for _, n := range strings.Split(string(data), "\n") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
+
+// lastBackupFilename returns the name of the most recent backup of the
+// database saved under filename.
+func (u *Updater) lastBackupFilename(fs FileSystem, filename string) (string, error) {
+	if u.BackupName == nil {
+		return backupFilename(filename, 1), nil
+	}
+	names, err := u.backupNames(fs, filename)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no backups: %w", os.ErrNotExist)
+	}
+	return names[0], nil
+}
+
+// Change is a summary of differences between two versions of the database,
+// based on their metadata and file sizes.
+type Change struct {
+	Old     *Metadata
+	New     *Metadata
+	OldSize int64
+	NewSize int64
+}
+
+// String returns a short description of the change.
+func (c *Change) String() string {
+	return fmt.Sprintf("build epoch changed from %s to %s, node count from %v to %v, size from %v to %v bytes",
+		c.Old.BuildEpoch.Format(time.RFC3339), c.New.BuildEpoch.Format(time.RFC3339),
+		c.Old.NodeCount, c.New.NodeCount,
+		c.OldSize, c.NewSize,
+	)
+}
+
+// CompareDatabases returns the Change between the databases saved under
+// oldFilename and newFilename.
+func CompareDatabases(oldFilename, newFilename string) (*Change, error) {
+	return (&Updater{}).compare(osFileSystem{}, oldFilename, newFilename)
+}
+
+// LastChange returns the Change between the most recent backup and the
+// database saved under filename. Backups must be enabled for the backup
+// to be saved by the update, otherwise an error that wraps os.ErrNotExist
+// is returned.
+func (u *Updater) LastChange(filename string) (*Change, error) {
+	fs := u.fileSystem()
+	backupFilename, err := u.lastBackupFilename(fs, filename)
+	if err != nil {
+		return nil, err
+	}
+	return u.compare(fs, backupFilename, filename)
+}
+
+// compare returns the Change between two databases on the filesystem.
+func (u *Updater) compare(fs FileSystem, oldFilename, newFilename string) (c *Change, err error) {
+	c = new(Change)
+	if c.Old, c.OldSize, err = u.readMetadataSize(fs, oldFilename); err != nil {
+		return nil, fmt.Errorf("old database: %w", err)
+	}
+	if c.New, c.NewSize, err = u.readMetadataSize(fs, newFilename); err != nil {
+		return nil, fmt.Errorf("new database: %w", err)
+	}
+	return c, nil
+}
+
+// readMetadataSize returns metadata and the file size of the database saved
+// under filename on the filesystem.
+func (u *Updater) readMetadataSize(fs FileSystem, filename string) (*Metadata, int64, error) {
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	md, err := u.readMetadata(fs, filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	return md, info.Size(), nil
+}
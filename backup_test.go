@@ -0,0 +1,204 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_Backups(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		Backups:    2,
+		FileSystem: fs,
+	}
+
+	var dbs [][]byte
+	for i := 0; i < 4; i++ {
+		db := mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(time.Duration(i)*time.Hour))
+		dbs = append(dbs, db)
+		ts.SetDatabase("GeoLite2-City", db)
+
+		if _, err := u.City(context.Background(), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string][]byte{
+		filename:        dbs[3],
+		filename + ".1": dbs[2],
+		filename + ".2": dbs[1],
+	} {
+		if got := fs.file(name); !bytes.Equal(got, want) {
+			t.Errorf("got invalid %s", name)
+		}
+	}
+	if got := fs.file(filename + ".3"); got != nil {
+		t.Errorf("got %s", filename+".3")
+	}
+}
+
+func TestUpdater_LastChange(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		Backups:    1,
+		FileSystem: fs,
+	}
+
+	oldDB := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts.SetDatabase("GeoLite2-City", oldDB)
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := u.LastChange(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+
+	newBuildEpoch := testBuildEpoch.Add(24 * time.Hour)
+	newDB := mmdbtest.Database("GeoLite2-City", newBuildEpoch)
+	ts.SetDatabase("GeoLite2-City", newDB)
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := u.LastChange(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Old.BuildEpoch.Equal(testBuildEpoch) {
+		t.Errorf("got old build epoch %v, want %v", c.Old.BuildEpoch, testBuildEpoch)
+	}
+	if !c.New.BuildEpoch.Equal(newBuildEpoch) {
+		t.Errorf("got new build epoch %v, want %v", c.New.BuildEpoch, newBuildEpoch)
+	}
+	if c.OldSize != int64(len(oldDB)) {
+		t.Errorf("got old size %v, want %v", c.OldSize, len(oldDB))
+	}
+	if c.NewSize != int64(len(newDB)) {
+		t.Errorf("got new size %v, want %v", c.NewSize, len(newDB))
+	}
+	want := fmt.Sprintf("build epoch changed from %s to %s, node count from 1 to 1, size from %v to %v bytes",
+		testBuildEpoch.UTC().Format(time.RFC3339), newBuildEpoch.UTC().Format(time.RFC3339), len(oldDB), len(newDB))
+	if got := c.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCompareDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldFilename := filepath.Join(dir, "old.mmdb")
+	newFilename := filepath.Join(dir, "new.mmdb")
+	newBuildEpoch := testBuildEpoch.Add(24 * time.Hour)
+	oldDB := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	newDB := mmdbtest.Database("GeoLite2-City", newBuildEpoch)
+	if err := ioutil.WriteFile(oldFilename, oldDB, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newFilename, newDB, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := CompareDatabases(oldFilename, newFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Old.BuildEpoch.Equal(testBuildEpoch) {
+		t.Errorf("got old build epoch %v, want %v", c.Old.BuildEpoch, testBuildEpoch)
+	}
+	if !c.New.BuildEpoch.Equal(newBuildEpoch) {
+		t.Errorf("got new build epoch %v, want %v", c.New.BuildEpoch, newBuildEpoch)
+	}
+	if c.OldSize != int64(len(oldDB)) {
+		t.Errorf("got old size %v, want %v", c.OldSize, len(oldDB))
+	}
+	if c.NewSize != int64(len(newDB)) {
+		t.Errorf("got new size %v, want %v", c.NewSize, len(newDB))
+	}
+
+	if _, err := CompareDatabases(filepath.Join(dir, "missing.mmdb"), newFilename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestUpdater_BackupName(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		Backups:    2,
+		FileSystem: fs,
+		BackupName: func(filename string, t time.Time) string {
+			return filename + "." + t.Format("2006-01-02")
+		},
+	}
+
+	var dbs [][]byte
+	for i := 0; i < 4; i++ {
+		db := mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(time.Duration(i)*24*time.Hour))
+		dbs = append(dbs, db)
+		ts.SetDatabase("GeoLite2-City", db)
+
+		if _, err := u.City(context.Background(), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string][]byte{
+		filename:                 dbs[3],
+		filename + ".2020-01-04": dbs[2],
+		filename + ".2020-01-03": dbs[1],
+	} {
+		if got := fs.file(name); !bytes.Equal(got, want) {
+			t.Errorf("got invalid %s", name)
+		}
+	}
+	if got := fs.file(filename + ".2020-01-02"); got != nil {
+		t.Errorf("got %s", filename+".2020-01-02")
+	}
+	if got, want := string(fs.file(filename+".backups")), filename+".2020-01-04\n"+filename+".2020-01-03\n"; got != want {
+		t.Errorf("got backups file %q, want %q", got, want)
+	}
+
+	c, err := u.LastChange(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testBuildEpoch.Add(2 * 24 * time.Hour); !c.Old.BuildEpoch.Equal(want) {
+		t.Errorf("got old build epoch %v, want %v", c.Old.BuildEpoch, want)
+	}
+}
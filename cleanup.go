@@ -0,0 +1,62 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Cleanup removes temporary and partial files of the edition that are left
+// by updates of the database saved under filename that were interrupted,
+// for example by a process crash. It is safe to call it before any update,
+// at the start of the program, but not concurrently with an update of the
+// same database. Partial archives saved with the Resume option are removed,
+// so the next update downloads the whole archive, as well as the database
+// file that was moved aside on Windows while it was memory mapped. The MD5
+// sum file saved by previous versions as geoip_download is removed once the
+// edition has its own state file.
+func (u *Updater) Cleanup(filename, editionID string) error {
+	fs := u.fileSystem()
+
+	partFilename := u.partFilename(filename, editionID)
+	filenames := []string{
+		filename + ".tmp",
+		filename + oldSuffix,
+		u.md5Filename(filename, editionID, u.suffix()) + ".tmp",
+		u.stateFilename(filename, editionID, u.suffix()) + ".tmp",
+		partFilename,
+		partFilename + ".md5",
+		partFilename + ".md5.tmp",
+	}
+	if u.Backups > 0 {
+		if u.BackupName != nil {
+			filenames = append(filenames, backupsFilename(filename)+".tmp")
+		} else {
+			filenames = append(filenames, backupFilename(filename, 1)+".tmp")
+		}
+	}
+	if u.ArchiveDir != "" {
+		filenames = append(filenames, u.archiveFilename(editionID)+".tmp")
+	}
+	// the legacy MD5 sum file is not needed when the edition has its own
+	// state file
+	if _, err := fs.Stat(u.stateFilename(filename, editionID, u.suffix())); err == nil {
+		filenames = append(filenames, legacyMD5Filename(filename))
+	}
+	for _, name := range u.AuxiliaryFiles {
+		filenames = append(filenames, filepath.Join(filepath.Dir(filename), filepath.Base(name))+".tmp")
+	}
+
+	for _, name := range filenames {
+		if err := fs.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdater_Cleanup(t *testing.T) {
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	if err := fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	keep := []string{
+		filepath.FromSlash("/data/GeoLite2-City.tar.gz.md5"),
+		filepath.FromSlash("/data/city.mmdb"),
+		filepath.FromSlash("/data/other.mmdb.tmp"),
+	}
+	for _, name := range append([]string{
+		filepath.FromSlash("/data/GeoLite2-City.tar.gz.md5.tmp"),
+		filepath.FromSlash("/data/GeoLite2-City.tar.gz.part"),
+		filepath.FromSlash("/data/GeoLite2-City.tar.gz.part.md5"),
+		filepath.FromSlash("/data/city.mmdb.1.tmp"),
+		filepath.FromSlash("/data/city.mmdb.old"),
+		filepath.FromSlash("/data/city.mmdb.tmp"),
+	}, keep...) {
+		if err := writeFile(fs, name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u := &Updater{
+		FileSystem: fs,
+		Backups:    1,
+	}
+	if err := u.Cleanup(filename, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.filenames(); !reflect.DeepEqual(got, keep) {
+		t.Errorf("got files %q, want %q", got, keep)
+	}
+
+	// nothing to remove
+	if err := u.Cleanup(filename, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewLocalAddrClient returns an HTTP client that makes connections from the
+// local address, for example to use a specific network interface on hosts
+// with multiple ones. It can be set as the Updater Client. Other transport
+// options are the same as of http.DefaultTransport.
+func NewLocalAddrClient(localAddr net.Addr) *http.Client {
+	t := newTransport()
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: localAddr,
+	}).DialContext
+	return &http.Client{
+		Transport: t,
+	}
+}
+
+// NewKeepAliveClient returns an HTTP client that keeps up to
+// maxIdleConnsPerHost idle connections to every host for the
+// idleConnTimeout duration, so that they are reused by updates of multiple
+// editions, and negotiates HTTP/2 with servers that support it. If
+// maxIdleConnsPerHost or idleConnTimeout are not positive, the values of
+// http.DefaultTransport are used. It can be set as the Updater Client.
+func NewKeepAliveClient(maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Client {
+	t := newTransport()
+	t.ForceAttemptHTTP2 = true
+	if maxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		if t.MaxIdleConns != 0 && t.MaxIdleConns < maxIdleConnsPerHost {
+			t.MaxIdleConns = maxIdleConnsPerHost
+		}
+	}
+	if idleConnTimeout > 0 {
+		t.IdleConnTimeout = idleConnTimeout
+	}
+	return &http.Client{
+		Transport: t,
+	}
+}
+
+// newTransport returns a copy of http.DefaultTransport for clients that
+// require different transport options. Proxies are always configured by the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, even if
+// http.DefaultTransport is replaced, so that a proxy works in the same way
+// with and without a client from this package.
+func newTransport() *http.Transport {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	t = t.Clone()
+	if t.Proxy == nil {
+		t.Proxy = http.ProxyFromEnvironment
+	}
+	return t
+}
+
+// ErrCertificateNotPinned is returned when no certificate in the verified
+// chain of the server has a pinned public key.
+var ErrCertificateNotPinned = errors.New("certificate not pinned")
+
+// NewCertPinClient returns an HTTP client that, in addition to the standard
+// TLS verification, accepts only servers with a certificate in the verified
+// chain that has one of the pinned public keys. Fingerprints are hex encoded
+// SHA256 sums of DER encoded public keys, the certificate
+// SubjectPublicKeyInfo, optionally separated by colons. As the MaxMind
+// endpoint redirects downloads to a storage host, its keys must be pinned,
+// too. It can be set as the Updater Client.
+func NewCertPinClient(fingerprints ...string) *http.Client {
+	pins := make(map[string]struct{}, len(fingerprints))
+	for _, f := range fingerprints {
+		pins[strings.ToLower(strings.Replace(f, ":", "", -1))] = struct{}{}
+	}
+	t := newTransport()
+	t.TLSClientConfig = &tls.Config{
+		VerifyPeerCertificate: func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if _, ok := pins[hex.EncodeToString(sum[:])]; ok {
+						return nil
+					}
+				}
+			}
+			return ErrCertificateNotPinned
+		},
+	}
+	return &http.Client{
+		Transport: t,
+	}
+}
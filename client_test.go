@@ -0,0 +1,157 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewLocalAddrClient(t *testing.T) {
+	var remoteAddr string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		fmt.Fprintln(w, "d41d8cd98f00b204e9800998ecf8427e")
+	}))
+	defer ts.Close()
+
+	client := NewLocalAddrClient(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer client.CloseIdleConnections()
+
+	if _, err := (&Updater{BaseURL: ts.URL, Client: client}).RemoteMD5(context.Background(), "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" {
+		t.Errorf("got remote address %q, want %q", host, "127.0.0.1")
+	}
+}
+
+func TestNewCertPinClient(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "d41d8cd98f00b204e9800998ecf8427e")
+	}))
+	// rejected handshakes are logged by the server
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	for _, tc := range []struct {
+		name        string
+		fingerprint string
+		wantErr     error
+	}{
+		{
+			name:        "pinned",
+			fingerprint: fmt.Sprintf("%X", sum),
+		},
+		{
+			name:        "pinned with colons",
+			fingerprint: fmt.Sprintf("% x", sum),
+		},
+		{
+			name:        "not pinned",
+			fingerprint: fmt.Sprintf("%x", sha256.Sum256(nil)),
+			wantErr:     ErrCertificateNotPinned,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewCertPinClient(strings.Replace(tc.fingerprint, " ", ":", -1))
+			defer client.CloseIdleConnections()
+			client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+			_, err := (&Updater{BaseURL: ts.URL, Client: client}).RemoteMD5(context.Background(), "GeoLite2-City")
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTransport_proxy(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	for _, tc := range []struct {
+		name      string
+		transport http.RoundTripper
+	}{
+		{
+			name:      "default",
+			transport: defaultTransport,
+		},
+		{
+			name:      "without proxy",
+			transport: &http.Transport{},
+		},
+		{
+			name: "custom",
+			transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				return nil, errors.New("not implemented")
+			}),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			http.DefaultTransport = tc.transport
+
+			if newTransport().Proxy == nil {
+				t.Error("got no proxy function")
+			}
+		})
+	}
+}
+
+func TestNewKeepAliveClient(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "d41d8cd98f00b204e9800998ecf8427e")
+	}))
+	var mu sync.Mutex
+	var conns int
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client := NewKeepAliveClient(4, time.Minute)
+	defer client.CloseIdleConnections()
+
+	u := &Updater{BaseURL: ts.URL, Client: client}
+	for _, editionID := range []string{"GeoLite2-Country", "GeoLite2-City", "GeoLite2-ASN"} {
+		if _, err := u.RemoteMD5(context.Background(), editionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("got %v connections, want %v", conns, 1)
+	}
+}
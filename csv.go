@@ -0,0 +1,183 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// zipSuffix is the download suffix of CSV database zip archives.
+const zipSuffix = "zip"
+
+// GeoLite2 CSV edition IDs.
+var (
+	geoLite2CityCSVEditionID    = "GeoLite2-City-CSV"
+	geoLite2CountryCSVEditionID = "GeoLite2-Country-CSV"
+	geoLite2ASNCSVEditionID     = "GeoLite2-ASN-CSV"
+)
+
+// UpdateGeoLite2CountryCSV downloads and updates GeoLite2 Country CSV files
+// and saves them in the directory. MD5 sum of the zip archive is saved in a
+// file in the same directory for update checks.
+func UpdateGeoLite2CountryCSV(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateCSV(ctx, dir, geoLite2CountryCSVEditionID)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2CityCSV downloads and updates GeoLite2 City CSV files and
+// saves them in the directory. MD5 sum of the zip archive is saved in a file
+// in the same directory for update checks.
+func UpdateGeoLite2CityCSV(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateCSV(ctx, dir, geoLite2CityCSVEditionID)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2ASNCSV downloads and updates GeoLite2 ASN CSV files and
+// saves them in the directory. MD5 sum of the zip archive is saved in a file
+// in the same directory for update checks.
+func UpdateGeoLite2ASNCSV(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateCSV(ctx, dir, geoLite2ASNCSVEditionID)
+	return r.Saved, err
+}
+
+// UpdateCSV downloads and updates CSV files of the provided MaxMind CSV
+// edition, like GeoLite2-City-CSV, and saves all files from the zip archive
+// in the directory. MD5 sum of the zip archive is saved in a file in the same
+// directory for update checks. Result Bytes is the total size of saved
+// files.
+func (u *Updater) UpdateCSV(ctx context.Context, dir, editionID string) (result Result, err error) {
+	defer func() { u.recordUpdate(editionID, result, err) }()
+	defer func() {
+		if err != nil {
+			err = &EditionError{EditionID: editionID, Err: err}
+		}
+	}()
+
+	// state files are saved in the directory
+	filename := filepath.Join(dir, editionID)
+
+	fs := u.fileSystem()
+
+	if err := fs.MkdirAll(dir, u.dirMode()); err != nil {
+		return Result{}, fmt.Errorf("create directory: %w", err)
+	}
+
+	unlock, err := u.lock(fs, filename)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	checksum, updateAvailable, err := u.check(ctx, filename, editionID, zipSuffix)
+	if err != nil {
+		return result, err
+	}
+	result.Edition = editionID
+	result.MD5 = string(checksum)
+	if !updateAvailable {
+		result.FromCache = true
+		u.Hooks.skip(editionID)
+		return result, nil
+	}
+
+	if err := probeWritable(fs, filename); err != nil {
+		return Result{}, err
+	}
+
+	tmpFilename := filepath.Join(dir, editionID+"."+zipSuffix+".tmp")
+	f, err := fs.OpenFile(tmpFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, u.fileMode())
+	if err != nil {
+		return Result{}, fmt.Errorf("create zip file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = fs.Remove(tmpFilename)
+	}()
+
+	start := time.Now()
+	size, err := u.downloadZip(ctx, f, dir, editionID, checksum)
+	if err != nil {
+		return Result{}, err
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return Result{}, fmt.Errorf("zip reader: %w", err)
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		n, err := u.extractZipFile(fs, dir, zf)
+		if err != nil {
+			return Result{}, fmt.Errorf("extract %s: %w", zf.Name, err)
+		}
+		result.Bytes += n
+	}
+	result.Saved = true
+
+	if err := u.writeState(fs, filename, editionID, zipSuffix, checksum, nil); err != nil {
+		return result, err
+	}
+
+	u.Hooks.downloadComplete(editionID, result.Bytes, time.Since(start))
+
+	return result, nil
+}
+
+// downloadZip downloads the zip archive of the edition to the writer and
+// compares its MD5 sum with the expected checksum. The directory is used for
+// the disk space check.
+func (u *Updater) downloadZip(ctx context.Context, w io.Writer, dir, editionID string, checksum []byte) (n int64, err error) {
+	r, err := u.get(ctx, editionID, zipSuffix, time.Time{})
+	if err != nil {
+		return 0, fmt.Errorf("get zip: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := u.checkDiskSpace(dir, r.ContentLength); err != nil {
+		return 0, err
+	}
+
+	u.Hooks.downloadStart(editionID, r.ContentLength)
+
+	body, report := u.countBody(editionID, r.Body)
+	defer report()
+	if u.Progress != nil {
+		body = &progressReader{r: body, total: r.ContentLength, f: u.Progress}
+	}
+	h, algorithm := newChecksumHash(checksum)
+	n, err = u.copy(io.MultiWriter(w, h), body)
+	if err != nil {
+		return 0, fmt.Errorf("download zip: %w", err)
+	}
+	if err := checkLength(r, n); err != nil {
+		return 0, err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != string(checksum) {
+		return 0, fmt.Errorf("zip %s %s, expected %s: %w", algorithm, got, checksum, ErrChecksumMismatch)
+	}
+	return n, nil
+}
+
+// extractZipFile saves the file from the zip archive in the directory,
+// without the directory structure from the archive.
+func (u *Updater) extractZipFile(fs FileSystem, dir string, zf *zip.File) (n int64, err error) {
+	r, err := zf.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	return writeFileFrom(fs, filepath.Join(dir, filepath.Base(zf.Name)), r, u.fileMode())
+}
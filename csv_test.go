@@ -0,0 +1,139 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_UpdateCSV(t *testing.T) {
+	files := map[string]string{
+		"GeoLite2-City-Blocks-IPv4.csv":  "network,geoname_id\n1.0.0.0/24,2077456\n",
+		"GeoLite2-City-Locations-en.csv": "geoname_id,locale_code\n2077456,en\n",
+		"COPYRIGHT.txt":                  "copyright",
+		"LICENSE.txt":                    "license",
+	}
+
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+	ts.SetArchive("GeoLite2-City-CSV", "zip", testZipArchive(t, "GeoLite2-City-CSV_20200102", files))
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &Updater{BaseURL: ts.URL}
+
+	result, err := u.UpdateCSV(context.Background(), dir, "GeoLite2-City-CSV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected files to be saved, but they are not")
+	}
+
+	var size int64
+	for name, data := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: got %q, want %q", name, got, data)
+		}
+		size += int64(len(data))
+	}
+	if result.Bytes != size {
+		t.Errorf("got %v bytes, want %v", result.Bytes, size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "GeoLite2-City-CSV.zip.md5")); err != nil {
+		t.Error(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files)+1 {
+		t.Errorf("got %v files, want %v", len(entries), len(files)+1)
+	}
+
+	result, err = u.UpdateCSV(context.Background(), dir, "GeoLite2-City-CSV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected files not to be saved, but they are")
+	}
+}
+
+func TestUpdateGeoLite2ASNCSV(t *testing.T) {
+	files := map[string]string{
+		"GeoLite2-ASN-Blocks-IPv4.csv": "network,autonomous_system_number\n1.0.0.0/24,13335\n",
+	}
+
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+	ts.SetArchive("GeoLite2-ASN-CSV", "zip", testZipArchive(t, "GeoLite2-ASN-CSV_20200102", files))
+
+	defer setTestTransport(t, ts.URL)()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	saved, err := UpdateGeoLite2ASNCSV(context.Background(), dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved {
+		t.Error("expected files to be saved, but they are not")
+	}
+	for name, data := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: got %q, want %q", name, got, data)
+		}
+	}
+}
+
+// testZipArchive returns a zip archive with files in the directory.
+func testZipArchive(t *testing.T, dir string, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create(dir + "/"); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		w, err := zw.Create(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
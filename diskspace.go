@@ -0,0 +1,47 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInsufficientDiskSpace is returned when there is not enough free disk
+// space to save the database.
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// diskSpaceMultiplier is the estimated ratio between the size of the
+// extracted database and the size of the compressed archive.
+const diskSpaceMultiplier = 3
+
+// freeDiskSpaceFunc is used for getting the available disk space and it is
+// replaced in tests.
+var freeDiskSpaceFunc = freeDiskSpace
+
+// checkDiskSpace returns ErrInsufficientDiskSpace if the estimated size of
+// the database extracted from the archive with the provided size is larger
+// than the available space on the filesystem where the directory is. The
+// check is skipped if the archive size or the available space is not known.
+func (u *Updater) checkDiskSpace(dir string, archiveSize int64) error {
+	if u.SkipDiskSpaceCheck || archiveSize <= 0 {
+		return nil
+	}
+	if _, ok := u.fileSystem().(osFileSystem); !ok {
+		return nil
+	}
+	free, ok, err := freeDiskSpaceFunc(dir)
+	if err != nil {
+		return fmt.Errorf("free disk space: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if required := uint64(archiveSize) * diskSpaceMultiplier; required > free {
+		return fmt.Errorf("%w: required %v bytes, available %v bytes", ErrInsufficientDiskSpace, required, free)
+	}
+	return nil
+}
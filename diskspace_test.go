@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_diskSpace(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	archiveSize := uint64(len(ts.Archive("GeoLite2-City")))
+
+	for _, tc := range []struct {
+		name    string
+		free    uint64
+		skip    bool
+		wantErr error
+	}{
+		{
+			name: "enough space",
+			free: archiveSize * diskSpaceMultiplier,
+		},
+		{
+			name:    "insufficient space",
+			free:    archiveSize,
+			wantErr: ErrInsufficientDiskSpace,
+		},
+		{
+			name: "skip check",
+			free: archiveSize,
+			skip: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func(f func(string) (uint64, bool, error)) { freeDiskSpaceFunc = f }(freeDiskSpaceFunc)
+			freeDiskSpaceFunc = func(string) (uint64, bool, error) {
+				return tc.free, true, nil
+			}
+
+			dir, err := ioutil.TempDir("", "mmdb_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			u := &Updater{
+				BaseURL:            ts.URL,
+				SkipDiskSpaceCheck: tc.skip,
+			}
+			result, err := u.City(context.Background(), filepath.Join(dir, "city.mmdb"))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if result.Saved != (tc.wantErr == nil) {
+				t.Errorf("got saved %v", result.Saved)
+			}
+		})
+	}
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	free, ok, err := freeDiskSpace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok && free == 0 {
+		t.Error("got no free disk space")
+	}
+}
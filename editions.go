@@ -0,0 +1,223 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrency is the default number of editions that are updated
+// concurrently by UpdateEditions.
+const DefaultConcurrency = 3
+
+// Edition holds information about a MaxMind database edition.
+type Edition struct {
+	// ID is the edition ID used in download requests.
+	ID string
+	// Name is the human-readable name of the edition.
+	Name string
+	// Filename is the name of the database file in the tar archive.
+	Filename string
+}
+
+// Editions are known MaxMind database editions keyed by their edition IDs.
+var Editions = map[string]Edition{
+	geoLite2CityEditionID: {
+		ID:       geoLite2CityEditionID,
+		Name:     "GeoLite2 City",
+		Filename: geoLite2CityEditionID + ".mmdb",
+	},
+	geoLite2CountryEditionID: {
+		ID:       geoLite2CountryEditionID,
+		Name:     "GeoLite2 Country",
+		Filename: geoLite2CountryEditionID + ".mmdb",
+	},
+	geoLite2ASNEditionID: {
+		ID:       geoLite2ASNEditionID,
+		Name:     "GeoLite2 ASN",
+		Filename: geoLite2ASNEditionID + ".mmdb",
+	},
+	geoIP2CityEditionID: {
+		ID:       geoIP2CityEditionID,
+		Name:     "GeoIP2 City",
+		Filename: geoIP2CityEditionID + ".mmdb",
+	},
+	geoIP2CountryEditionID: {
+		ID:       geoIP2CountryEditionID,
+		Name:     "GeoIP2 Country",
+		Filename: geoIP2CountryEditionID + ".mmdb",
+	},
+	geoIP2ISPEditionID: {
+		ID:       geoIP2ISPEditionID,
+		Name:     "GeoIP2 ISP",
+		Filename: geoIP2ISPEditionID + ".mmdb",
+	},
+	"GeoIP2-Anonymous-IP": {
+		ID:       "GeoIP2-Anonymous-IP",
+		Name:     "GeoIP2 Anonymous IP",
+		Filename: "GeoIP2-Anonymous-IP.mmdb",
+	},
+	"GeoIP2-Connection-Type": {
+		ID:       "GeoIP2-Connection-Type",
+		Name:     "GeoIP2 Connection Type",
+		Filename: "GeoIP2-Connection-Type.mmdb",
+	},
+	"GeoIP2-Domain": {
+		ID:       "GeoIP2-Domain",
+		Name:     "GeoIP2 Domain",
+		Filename: "GeoIP2-Domain.mmdb",
+	},
+	"GeoIP2-Enterprise": {
+		ID:       "GeoIP2-Enterprise",
+		Name:     "GeoIP2 Enterprise",
+		Filename: "GeoIP2-Enterprise.mmdb",
+	},
+}
+
+// KnownEditions returns all known editions sorted by their edition IDs.
+func KnownEditions() []Edition {
+	editions := make([]Edition, 0, len(Editions))
+	for _, e := range Editions {
+		editions = append(editions, e)
+	}
+	sort.Slice(editions, func(i, j int) bool {
+		return editions[i].ID < editions[j].ID
+	})
+	return editions
+}
+
+// editionFilename returns the standard name of the database file of the
+// edition.
+func editionFilename(editionID string) string {
+	if e, ok := Editions[editionID]; ok && e.Filename != "" {
+		return e.Filename
+	}
+	return editionID + ".mmdb"
+}
+
+// UpdateToDir downloads and updates a database of the provided edition and
+// saves it in the directory under its standard filename, the edition ID with
+// .mmdb extension. The directory is created if it does not exist.
+func (u *Updater) UpdateToDir(ctx context.Context, dir, editionID string) (Result, error) {
+	return u.Update(ctx, filepath.Join(dir, editionFilename(editionID)), editionID)
+}
+
+// UpdateAll downloads and updates GeoLite2 City, Country and ASN databases
+// concurrently and saves them in the directory, named by their edition IDs
+// with .mmdb extension. Returned map holds information which databases are
+// saved, keyed by the edition ID. Errors for individual editions are
+// returned as Errors.
+func UpdateAll(ctx context.Context, dir, licenseKey string) (saved map[string]bool, err error) {
+	results, err := (&Updater{LicenseKey: licenseKey}).UpdateEditions(ctx, dir,
+		geoLite2CityEditionID,
+		geoLite2CountryEditionID,
+		geoLite2ASNEditionID,
+	)
+	saved = make(map[string]bool, len(results))
+	for editionID, r := range results {
+		saved[editionID] = r.Saved
+	}
+	return saved, err
+}
+
+// UpdateEditions downloads and updates databases of the provided editions
+// concurrently, at most Concurrency of them at the same time, and saves them
+// in the directory, named by their edition IDs with .mmdb extension. Results
+// are returned for all editions that are updated without an error, keyed by
+// the edition ID. Errors for individual editions are returned as Errors.
+func (u *Updater) UpdateEditions(ctx context.Context, dir string, editionIDs ...string) (map[string]Result, error) {
+	concurrency := u.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	var (
+		results = make(map[string]Result, len(editionIDs))
+		errs    Errors
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, concurrency)
+	)
+	for _, editionID := range editionIDs {
+		editionID := editionID
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			r, err := u.UpdateToDir(ctx, dir, editionID)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				// errors from update already hold the edition ID
+				var e *EditionError
+				if !errors.As(err, &e) {
+					e = &EditionError{EditionID: editionID, Err: err}
+				}
+				errs = append(errs, e)
+				return
+			}
+			results[editionID] = r
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].EditionID < errs[j].EditionID
+		})
+		return results, errs
+	}
+	return results, nil
+}
+
+// EditionError is an error that occurred while updating a database of a
+// specific edition.
+type EditionError struct {
+	EditionID string
+	Err       error
+}
+
+func (e *EditionError) Error() string {
+	return e.EditionID + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *EditionError) Unwrap() error {
+	return e.Err
+}
+
+// Errors holds errors from updating multiple editions.
+type Errors []*EditionError
+
+func (e Errors) Error() string {
+	s := make([]string, 0, len(e))
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Is reports whether any of the errors matches the target.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_UpdateEditions(t *testing.T) {
+	databases := map[string][]byte{
+		"GeoLite2-City":    mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+		"GeoLite2-Country": mmdbtest.Database("GeoLite2-Country", testBuildEpoch),
+		"GeoLite2-ASN":     mmdbtest.Database("GeoLite2-ASN", testBuildEpoch),
+	}
+	ts := mmdbtest.NewServer(databases)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &Updater{
+		BaseURL:     ts.URL,
+		Concurrency: 2,
+	}
+	results, err := u.UpdateEditions(context.Background(), dir, "GeoLite2-City", "GeoLite2-Country", "GeoLite2-ASN", "GeoLite2-Missing")
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want Errors", err)
+	}
+	if len(errs) != 1 || errs[0].EditionID != "GeoLite2-Missing" {
+		t.Errorf("got errors %v, want one for GeoLite2-Missing", errs)
+	}
+
+	if len(results) != len(databases) {
+		t.Errorf("got %v results, want %v", len(results), len(databases))
+	}
+	for editionID, db := range databases {
+		if !results[editionID].Saved {
+			t.Errorf("%s: expected file to be saved, but it is not", editionID)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, editionID+".mmdb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, db) {
+			t.Errorf("%s: got database %q, want %q", editionID, got, db)
+		}
+	}
+}
+
+func TestUpdater_UpdateToDir(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the directory does not exist
+	dir = filepath.Join(dir, "databases")
+
+	u := &Updater{
+		BaseURL: ts.URL,
+	}
+	result, err := u.UpdateToDir(context.Background(), dir, "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "GeoLite2-City.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+}
+
+func TestUpdateAll(t *testing.T) {
+	databases := map[string][]byte{
+		"GeoLite2-City":    mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+		"GeoLite2-Country": mmdbtest.Database("GeoLite2-Country", testBuildEpoch),
+	}
+	ts := mmdbtest.NewServer(databases)
+	defer ts.Close()
+	defer setTestTransport(t, ts.URL)()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// GeoLite2-ASN is not served
+	saved, err := UpdateAll(context.Background(), dir, "test")
+	if !errors.Is(err, ErrEditionNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrEditionNotFound)
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want Errors", err)
+	}
+	if len(errs) != 1 || errs[0].EditionID != "GeoLite2-ASN" {
+		t.Errorf("got errors %v, want one for GeoLite2-ASN", errs)
+	}
+
+	if len(saved) != len(databases) {
+		t.Errorf("got %v saved, want %v", len(saved), len(databases))
+	}
+	for editionID, db := range databases {
+		if !saved[editionID] {
+			t.Errorf("%s: expected file to be saved, but it is not", editionID)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, editionID+".mmdb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, db) {
+			t.Errorf("%s: got database %q, want %q", editionID, got, db)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errTest := errors.New("test")
+	errs := Errors{
+		{EditionID: "GeoLite2-ASN", Err: ErrEditionNotFound},
+		{EditionID: "GeoLite2-City", Err: fmt.Errorf("download: %w", errTest)},
+	}
+
+	if got, want := errs.Error(), "GeoLite2-ASN: edition not found; GeoLite2-City: download: test"; got != want {
+		t.Errorf("got error message %q, want %q", got, want)
+	}
+
+	var err error = errs
+	for _, target := range []error{ErrEditionNotFound, errTest} {
+		if !errors.Is(err, target) {
+			t.Errorf("got error %v, want %v", err, target)
+		}
+	}
+	if errors.Is(err, ErrForbidden) {
+		t.Errorf("got error %v matching %v", err, ErrForbidden)
+	}
+	if errors.Is(Errors(nil), ErrEditionNotFound) {
+		t.Error("got empty errors matching")
+	}
+}
+
+func TestKnownEditions(t *testing.T) {
+	editions := KnownEditions()
+	if len(editions) != len(Editions) {
+		t.Fatalf("got %v editions, want %v", len(editions), len(Editions))
+	}
+	for i, e := range editions {
+		if i > 0 && editions[i-1].ID >= e.ID {
+			t.Errorf("edition %s is not sorted", e.ID)
+		}
+		if Editions[e.ID] != e {
+			t.Errorf("got edition %+v, want %+v", e, Editions[e.ID])
+		}
+		if want := e.ID + ".mmdb"; e.Filename != want {
+			t.Errorf("got edition %s filename %s, want %s", e.ID, e.Filename, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrInvalidLicenseKey is returned when the license key or the account
+	// ID is not valid or the license key is expired.
+	ErrInvalidLicenseKey = errors.New("invalid license key")
+	// ErrNoLicenseKey is returned when the license key is empty for
+	// downloads from the default MaxMind endpoint.
+	ErrNoLicenseKey = errors.New("no license key")
+	// ErrForbidden is returned when the account does not have access to the
+	// edition.
+	ErrForbidden = errors.New("forbidden")
+	// ErrEditionNotFound is returned when the edition or the download suffix
+	// does not exist.
+	ErrEditionNotFound = errors.New("edition not found")
+	// ErrRedirectNotFollowed is returned on a redirect response, when the
+	// HTTP client does not follow redirects. The MaxMind endpoint redirects
+	// downloads to pre-signed storage URLs, so the client must follow them.
+	ErrRedirectNotFollowed = errors.New("redirect not followed")
+	// ErrDestinationIsDirectory is returned when the database filename is
+	// an existing directory.
+	ErrDestinationIsDirectory = errors.New("destination is a directory, expected a file")
+	// ErrDestinationInUse is returned on Windows when a file can not be
+	// replaced because it is opened by another process without the delete
+	// sharing mode. Files that are only memory mapped are replaced.
+	ErrDestinationInUse = errors.New("destination is in use")
+	// ErrInvalidInterval is returned by Run when the update interval is not
+	// positive.
+	ErrInvalidInterval = errors.New("invalid interval")
+)
+
+// statusError returns the error for the response with the unexpected
+// status, wrapping a typed error for known statuses.
+func statusError(r *http.Response) error {
+	switch r.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%w: unexpected http response %s", ErrInvalidLicenseKey, r.Status)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: unexpected http response %s", ErrForbidden, r.Status)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: unexpected http response %s", ErrEditionNotFound, r.Status)
+	case http.StatusTooManyRequests:
+		return newRateLimitError(r)
+	}
+	if r.StatusCode >= 300 && r.StatusCode < 400 && r.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("%w: unexpected http response %s", ErrRedirectNotFollowed, r.Status)
+	}
+	return fmt.Errorf("unexpected http response %s", r.Status)
+}
+
+// ErrRateLimited is returned when the download is rejected because of too
+// many requests. The returned error is RateLimitError.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is returned on Too Many Requests response.
+type RateLimitError struct {
+	// RetryAfter is the duration from the Retry-After response header after
+	// which the request can be made again, or zero if it is not known.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return ErrRateLimited.Error() + ", retry after " + e.RetryAfter.String()
+	}
+	return ErrRateLimited.Error()
+}
+
+// Is reports whether the target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// newRateLimitError returns RateLimitError with the duration from the
+// Retry-After header of the response.
+func newRateLimitError(r *http.Response) *RateLimitError {
+	return &RateLimitError{
+		RetryAfter: parseRetryAfter(r.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter returns the duration from the Retry-After header value,
+// given in seconds or as the HTTP date, relative to now. Zero is returned if
+// the value is not valid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_rateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = (&Updater{BaseURL: ts.URL}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got error %v, want %v", err, ErrRateLimited)
+	}
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("got error %T, want %T", err, rateLimitErr)
+	}
+	if want := 2 * time.Minute; rateLimitErr.RetryAfter != want {
+		t.Errorf("got retry after %v, want %v", rateLimitErr.RetryAfter, want)
+	}
+}
+
+func TestUpdater_statusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		wantErr error
+	}{
+		{status: http.StatusUnauthorized, wantErr: ErrInvalidLicenseKey},
+		{status: http.StatusForbidden, wantErr: ErrForbidden},
+		{status: http.StatusNotFound, wantErr: ErrEditionNotFound},
+		{status: http.StatusTooManyRequests, wantErr: ErrRateLimited},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			_, err := (&Updater{BaseURL: ts.URL}).RemoteMD5(context.Background(), "GeoLite2-City")
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdater_redirect(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL+"?"+r.URL.RawQuery, http.StatusFound)
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := (&Updater{BaseURL: s.URL}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+
+	_, err = (&Updater{
+		BaseURL: s.URL,
+		Force:   true,
+		Client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if !errors.Is(err, ErrRedirectNotFollowed) {
+		t.Errorf("got error %v, want %v", err, ErrRedirectNotFollowed)
+	}
+}
+
+func TestUpdater_errorContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = (&Updater{BaseURL: ts.URL, LicenseKey: "secret"}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	var editionErr *EditionError
+	if !errors.As(err, &editionErr) {
+		t.Fatalf("got error %T, want %T", err, editionErr)
+	}
+	if editionErr.EditionID != "GeoLite2-City" {
+		t.Errorf("got edition id %q, want %q", editionErr.EditionID, "GeoLite2-City")
+	}
+	want := "GeoLite2-City: get md5 file: get " + ts.URL + "?edition_id=GeoLite2-City&license_key=redacted&suffix=tar.gz.md5: unexpected http response 500 Internal Server Error"
+	if got := err.Error(); got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "file.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	u := &Updater{BaseURL: ts.URL, LicenseKey: "secret"}
+	for _, tc := range []struct {
+		name      string
+		editionID string
+		update    func() (Result, error)
+	}{
+		{
+			name:      "csv",
+			editionID: "GeoLite2-City-CSV",
+			update: func() (Result, error) {
+				return u.UpdateCSV(context.Background(), filepath.Join(dir, "csv"), "GeoLite2-City-CSV")
+			},
+		},
+		{
+			name:      "url",
+			editionID: "GeoLite2-City.mmdb",
+			update: func() (Result, error) {
+				return u.UpdateFromURL(context.Background(), filepath.Join(dir, "url.mmdb"), "GeoLite2-City.mmdb", ts.URL+"/GeoLite2-City.tar.gz")
+			},
+		},
+		{
+			name:      "file",
+			editionID: "GeoLite2-City",
+			update: func() (Result, error) {
+				return u.UpdateFile(context.Background(), f, "GeoLite2-City")
+			},
+		},
+	} {
+		_, err := tc.update()
+		var editionErr *EditionError
+		if !errors.As(err, &editionErr) {
+			t.Errorf("%s: got error %T, want %T", tc.name, err, editionErr)
+			continue
+		}
+		if editionErr.EditionID != tc.editionID {
+			t.Errorf("%s: got edition id %q, want %q", tc.name, editionErr.EditionID, tc.editionID)
+		}
+		if !strings.HasPrefix(err.Error(), tc.editionID+": ") {
+			t.Errorf("%s: got error %q, want edition id prefix", tc.name, err)
+		}
+	}
+
+	// network errors
+	_, err = (&Updater{BaseURL: "http://127.0.0.1:0", LicenseKey: "secret"}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("got license key in error %q", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "30", want: 30 * time.Second},
+		{value: "-1", want: 0},
+		{value: now.Add(time.Hour).Format(http.TimeFormat), want: time.Hour},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0},
+		{value: "invalid", want: 0},
+	} {
+		if got := parseRetryAfter(tc.value, now); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
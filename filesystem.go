@@ -0,0 +1,101 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// FileSystem provides filesystem operations that Updater uses to save
+// databases and MD5 sum files. It allows saving files to a storage other
+// than the operating system filesystem.
+type FileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// File is a file opened by the FileSystem.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+}
+
+// osFileSystem is a FileSystem implementation that uses the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return rename(oldpath, newpath)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// readFile reads the whole file from the filesystem.
+func readFile(fs FileSystem, filename string) ([]byte, error) {
+	f, err := fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// writeFile writes data to a temporary file and renames it to filename, so
+// that the file is never left partially written.
+func writeFile(fs FileSystem, filename string, data []byte, perm os.FileMode) error {
+	_, err := writeFileFrom(fs, filename, bytes.NewReader(data), perm)
+	return err
+}
+
+// writeFileFrom writes data from the reader to a temporary file and renames
+// it to filename, returning the number of written bytes.
+func writeFileFrom(fs FileSystem, filename string, r io.Reader, perm os.FileMode) (n int64, err error) {
+	tmpFilename := filename + ".tmp"
+	f, err := fs.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(tmpFilename)
+		}
+	}()
+
+	n, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, fs.Rename(tmpFilename, filename)
+}
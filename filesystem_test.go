@@ -0,0 +1,348 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_FileSystem(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: fs,
+	}
+
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+
+	wantFiles := []string{
+		filepath.FromSlash("/data/GeoLite2-City.tar.gz.md5"),
+		filename,
+	}
+	gotFiles := fs.filenames()
+	if len(gotFiles) != len(wantFiles) {
+		t.Fatalf("got files %v, want %v", gotFiles, wantFiles)
+	}
+	for i := range gotFiles {
+		if gotFiles[i] != wantFiles[i] {
+			t.Errorf("got file %q, want %q", gotFiles[i], wantFiles[i])
+		}
+	}
+	if got := fs.file(filename); !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist error", err)
+	}
+}
+
+func TestUpdater_destinationIsDirectory(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	if err := fs.MkdirAll(filename, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: fs,
+	}
+	if _, err := u.City(context.Background(), filename); !errors.Is(err, ErrDestinationIsDirectory) {
+		t.Errorf("got error %v, want %v", err, ErrDestinationIsDirectory)
+	}
+	if _, err := u.UpdateFromURL(context.Background(), filename, "GeoLite2-City.mmdb", ts.URL+"/GeoLite2-City.tar.gz"); !errors.Is(err, ErrDestinationIsDirectory) {
+		t.Errorf("got error %v, want %v", err, ErrDestinationIsDirectory)
+	}
+	if got := len(ts.Requests()); got != 0 {
+		t.Errorf("got %v requests, want none", got)
+	}
+}
+
+func TestUpdater_notWritable(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: readOnlyFileSystem{newMemFileSystem()},
+	}
+
+	_, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got error %v, want %v", err, os.ErrPermission)
+	}
+	for _, r := range ts.Requests() {
+		if suffix := r.Query().Get("suffix"); suffix != "tar.gz.md5" {
+			t.Errorf("got request for %q", suffix)
+		}
+	}
+
+	ts.SetArchive("GeoLite2-City-CSV", "zip", []byte("zip archive"))
+	_, err = u.UpdateCSV(context.Background(), filepath.FromSlash("/data/csv"), "GeoLite2-City-CSV")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got csv error %v, want %v", err, os.ErrPermission)
+	}
+	for _, r := range ts.Requests() {
+		if suffix := r.Query().Get("suffix"); suffix == "zip" {
+			t.Errorf("got request for %q", suffix)
+		}
+	}
+
+	var archiveRequests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		archiveRequests++
+		_, _ = w.Write(ts.Archive("GeoLite2-City"))
+	}))
+	defer s.Close()
+	_, err = u.UpdateFromURL(context.Background(), filepath.FromSlash("/data/url.mmdb"), "GeoLite2-City.mmdb", s.URL+"/GeoLite2-City.tar.gz")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got url error %v, want %v", err, os.ErrPermission)
+	}
+	if archiveRequests != 0 {
+		t.Errorf("got %v archive requests, want none", archiveRequests)
+	}
+}
+
+// readOnlyFileSystem is a memFileSystem where files can not be created.
+type readOnlyFileSystem struct {
+	*memFileSystem
+}
+
+func (fs readOnlyFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_CREATE != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fs.memFileSystem.OpenFile(name, flag, perm)
+}
+
+// memFileSystem is an in-memory FileSystem implementation for tests.
+type memFileSystem struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]struct{}
+}
+
+func newMemFileSystem() *memFileSystem {
+	return &memFileSystem{
+		files: make(map[string][]byte),
+		dirs:  map[string]struct{}{filepath.FromSlash("/"): {}},
+	}
+}
+
+func (fs *memFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if _, ok := fs.dirs[filepath.Dir(name)]; !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+	} else if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	f := &memFile{
+		fs:       fs,
+		name:     name,
+		readable: flag&os.O_WRONLY == 0,
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+	}
+	if !f.writable || flag&os.O_TRUNC == 0 {
+		f.data = append([]byte(nil), data...)
+	}
+	if f.writable {
+		fs.files[name] = nil
+	}
+	return f, nil
+}
+
+func (fs *memFileSystem) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.dirs[name]; ok {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return memFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+}
+
+func (fs *memFileSystem) MkdirAll(p string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for ; p != filepath.Dir(p); p = filepath.Dir(p) {
+		fs.dirs[p] = struct{}{}
+	}
+	return nil
+}
+
+func (fs *memFileSystem) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, ok := fs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldpath)
+	fs.files[newpath] = data
+	return nil
+}
+
+func (fs *memFileSystem) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFileSystem) file(name string) []byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.files[name]
+}
+
+func (fs *memFileSystem) filenames() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	names := make([]string, 0, len(fs.files))
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memFile struct {
+	fs       *memFileSystem
+	name     string
+	data     []byte
+	off      int
+	readable bool
+	writable bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if !f.readable {
+		return 0, os.ErrPermission
+	}
+	if f.off >= len(f.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.readable {
+		return 0, os.ErrPermission
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, os.ErrPermission
+	}
+	f.data = append(f.data, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	if !f.writable {
+		return nil
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if _, ok := f.fs.files[f.name]; ok {
+		f.fs.files[f.name] = f.data
+	}
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+func (i memFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0777
+	}
+	return 0666
+}
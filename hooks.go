@@ -0,0 +1,48 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import "time"
+
+// Hooks are functions that are called by the Updater on update events, for
+// logging and observability. Any of them can be nil.
+type Hooks struct {
+	// OnSkip is called when the update is not needed.
+	OnSkip func(editionID string)
+	// OnDownloadStart is called when the archive download starts, with its
+	// size from the Content-Length header, or -1 if it is not known.
+	OnDownloadStart func(editionID string, size int64)
+	// OnDownloadComplete is called when the database is saved, with its size
+	// and the duration from the start of the download.
+	OnDownloadComplete func(editionID string, size int64, duration time.Duration)
+	// OnRetry is called before the request is retried, with the retry
+	// attempt number, starting from 1, and the error of the previous attempt.
+	OnRetry func(editionID string, attempt int, err error)
+}
+
+func (h Hooks) skip(editionID string) {
+	if h.OnSkip != nil {
+		h.OnSkip(editionID)
+	}
+}
+
+func (h Hooks) downloadStart(editionID string, size int64) {
+	if h.OnDownloadStart != nil {
+		h.OnDownloadStart(editionID, size)
+	}
+}
+
+func (h Hooks) downloadComplete(editionID string, size int64, duration time.Duration) {
+	if h.OnDownloadComplete != nil {
+		h.OnDownloadComplete(editionID, size, duration)
+	}
+}
+
+func (h Hooks) retry(editionID string, attempt int, err error) {
+	if h.OnRetry != nil {
+		h.OnRetry(editionID, attempt, err)
+	}
+}
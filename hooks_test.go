@@ -0,0 +1,93 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_Hooks(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	var mu sync.Mutex
+	failed := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := !failed
+		failed = true
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	var events []string
+	u := &Updater{
+		BaseURL:      s.URL,
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		Hooks: Hooks{
+			OnSkip: func(editionID string) {
+				events = append(events, "skip "+editionID)
+			},
+			OnDownloadStart: func(editionID string, size int64) {
+				events = append(events, fmt.Sprintf("start %s %v", editionID, size))
+			},
+			OnDownloadComplete: func(editionID string, size int64, duration time.Duration) {
+				if duration < 0 {
+					t.Errorf("got duration %v", duration)
+				}
+				events = append(events, fmt.Sprintf("complete %s %v", editionID, size))
+			},
+			OnRetry: func(editionID string, attempt int, err error) {
+				events = append(events, fmt.Sprintf("retry %s %v %v", editionID, attempt, err))
+			},
+		},
+	}
+
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		fmt.Sprintf("retry GeoLite2-City 1 get %s?edition_id=GeoLite2-City&license_key=redacted&suffix=tar.gz.md5: unexpected http response 502 Bad Gateway", s.URL),
+		fmt.Sprintf("start GeoLite2-City %v", len(ts.Archive("GeoLite2-City"))),
+		fmt.Sprintf("complete GeoLite2-City %v", len(db)),
+		"skip GeoLite2-City",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
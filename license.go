@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// LicenseKeyFromFile returns the license key read from the file, with the
+// surrounding whitespace removed.
+func LicenseKeyFromFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("read license key file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// LicenseKeyFromEnv returns the license key from the environment variable,
+// with the surrounding whitespace removed. An error is returned if the
+// variable is not set or if it is empty.
+func LicenseKeyFromEnv(name string) (string, error) {
+	key := strings.TrimSpace(os.Getenv(name))
+	if key == "" {
+		return "", fmt.Errorf("license key environment variable %s is not set", name)
+	}
+	return key, nil
+}
+
+// licenseKey returns the LicenseKey, or the one from the LicenseKeyFunc if
+// it is set, with the surrounding whitespace removed. ErrInvalidLicenseKey is
+// returned if the key contains whitespace or non-printable characters, which
+// are not valid in MaxMind license keys.
+func (u *Updater) licenseKey() (string, error) {
+	key := u.LicenseKey
+	if u.LicenseKeyFunc != nil {
+		var err error
+		key, err = u.LicenseKeyFunc()
+		if err != nil {
+			return "", fmt.Errorf("license key: %w", err)
+		}
+	}
+	key = strings.TrimSpace(key)
+	for _, c := range key {
+		if c <= ' ' || c > '~' {
+			return "", fmt.Errorf("%w: unexpected character %q", ErrInvalidLicenseKey, c)
+		}
+	}
+	return key, nil
+}
+
+// RedactURL returns the URL with the license_key query parameter value and
+// the password replaced, so that it can be safely logged. Errors returned by
+// Updater never contain the license key.
+func RedactURL(rawURL string) string {
+	addr, err := url.Parse(rawURL)
+	if err != nil {
+		// the query may contain the license key
+		if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+			return rawURL[:i]
+		}
+		return rawURL
+	}
+	q := addr.Query()
+	if _, ok := q["license_key"]; ok {
+		q.Set("license_key", "redacted")
+		addr.RawQuery = q.Encode()
+	}
+	if _, ok := addr.User.Password(); ok {
+		addr.User = url.UserPassword(addr.User.Username(), "redacted")
+	}
+	return addr.String()
+}
+
+// redactError returns the error with all occurrences of the license key in
+// its message replaced, or the same error if it does not contain the key.
+func redactError(err error, licenseKey string) error {
+	if err == nil || licenseKey == "" || !strings.Contains(err.Error(), licenseKey) {
+		return err
+	}
+	return &redactedError{err: err, licenseKey: licenseKey}
+}
+
+// redactedError hides the license key in the message of the wrapped error.
+type redactedError struct {
+	err        error
+	licenseKey string
+}
+
+func (e *redactedError) Error() string {
+	return strings.Replace(e.err.Error(), e.licenseKey, "redacted", -1)
+}
+
+// Unwrap returns the underlying error.
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
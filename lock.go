@@ -0,0 +1,91 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pathLock is a mutex for updates of the database saved under the same
+// filename, counting the number of updates that hold or wait for it.
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	pathLocks   = make(map[string]*pathLock)
+	pathLocksMu sync.Mutex
+)
+
+// lockPath serializes updates of the database saved under filename within
+// the process. The returned function releases the lock.
+func lockPath(filename string) (unlock func()) {
+	key := filepath.Clean(filename)
+	if abs, err := filepath.Abs(key); err == nil {
+		key = abs
+	}
+
+	pathLocksMu.Lock()
+	l, ok := pathLocks[key]
+	if !ok {
+		l = new(pathLock)
+		pathLocks[key] = l
+	}
+	l.refs++
+	pathLocksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		pathLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(pathLocks, key)
+		}
+		pathLocksMu.Unlock()
+	}
+}
+
+// lockFilename returns the name of the file that is locked with FileLock
+// option during the update of the database saved under filename.
+func lockFilename(filename string) string {
+	return filename + ".lock"
+}
+
+// lock serializes updates of the database saved under filename, within the
+// process and, with FileLock option and the operating system filesystem,
+// between processes with an advisory lock on the lock file. The directory
+// of the database must exist. The returned function releases the lock.
+func (u *Updater) lock(fs FileSystem, filename string) (unlock func(), err error) {
+	unlockPath := lockPath(filename)
+	if !u.FileLock {
+		return unlockPath, nil
+	}
+	if _, ok := fs.(osFileSystem); !ok {
+		return unlockPath, nil
+	}
+
+	f, err := os.OpenFile(lockFilename(filename), os.O_RDWR|os.O_CREATE, u.fileMode())
+	if err != nil {
+		unlockPath()
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		unlockPath()
+		return nil, fmt.Errorf("lock file: %w", err)
+	}
+	return func() {
+		// closing the file releases the lock
+		_ = f.Close()
+		unlockPath()
+	}, nil
+}
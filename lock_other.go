@@ -0,0 +1,17 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package mmdb
+
+import "os"
+
+// lockFile does not lock the file on platforms without supported advisory
+// locks.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_concurrent(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	for _, fileLock := range []bool{false, true} {
+		name := "path lock"
+		if fileLock {
+			name = "file lock"
+		}
+		dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "city.mmdb")
+
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var saved int
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					// every update uses its own Updater, as different callers
+					// would
+					u := &Updater{BaseURL: ts.URL, FileLock: fileLock}
+					result, err := u.City(context.Background(), filename)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if result.Saved {
+						mu.Lock()
+						saved++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if saved != 1 {
+				t.Errorf("got %v saved databases, want %v", saved, 1)
+			}
+			_, err = os.Stat(lockFilename(filename))
+			if fileLock && err != nil {
+				t.Errorf("got lock file error %v", err)
+			}
+			if !fileLock && !os.IsNotExist(err) {
+				t.Errorf("got lock file error %v, want not exist error", err)
+			}
+		})
+	}
+
+	pathLocksMu.Lock()
+	defer pathLocksMu.Unlock()
+	if len(pathLocks) != 0 {
+		t.Errorf("got %v path locks, want none", len(pathLocks))
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// metadataStartMarker marks the beginning of the metadata section in
+// MaxMind DB files.
+var metadataStartMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// metadataMaxSize is the maximal size of the file end that is searched for
+// the metadata section.
+const metadataMaxSize = 128 * 1024
+
+// ErrMetadataNotFound is returned when MaxMind DB metadata section can not be
+// found in the database.
+var ErrMetadataNotFound = errors.New("metadata not found")
+
+// ErrInvalidDatabase is returned when the downloaded database is not a valid
+// MaxMind DB database.
+var ErrInvalidDatabase = errors.New("invalid database")
+
+// Metadata holds information from the MaxMind DB metadata section.
+type Metadata struct {
+	BinaryFormatMajorVersion uint
+	BinaryFormatMinorVersion uint
+	BuildEpoch               time.Time
+	DatabaseType             string
+	Description              map[string]string
+	IPVersion                uint
+	Languages                []string
+	NodeCount                uint
+	RecordSize               uint
+}
+
+// ReadMetadata returns metadata of the MaxMind DB database saved under
+// filename. Database type is the same as the edition ID for MaxMind
+// databases.
+func ReadMetadata(filename string) (*Metadata, error) {
+	return readMetadata(osFileSystem{}, filename)
+}
+
+// readMetadata returns metadata of the database saved under filename on the
+// filesystem.
+func readMetadata(fs FileSystem, filename string) (*Metadata, error) {
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := info.Size()
+	if size > metadataMaxSize {
+		size = metadataMaxSize
+	}
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, info.Size()-size); err != nil {
+		return nil, err
+	}
+	return parseMetadata(b)
+}
+
+// readMetadata returns metadata of the database saved under filename on the
+// filesystem, decompressing it with GzipOutput option.
+func (u *Updater) readMetadata(fs FileSystem, filename string) (*Metadata, error) {
+	if !u.GzipOutput {
+		return readMetadata(fs, filename)
+	}
+	f, err := fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tail := &tailBuffer{size: metadataMaxSize}
+	if _, err := io.Copy(tail, gzr); err != nil {
+		return nil, err
+	}
+	return parseMetadata(tail.b)
+}
+
+// parseMetadata decodes the metadata section found in the end of the
+// database data.
+func parseMetadata(b []byte) (*Metadata, error) {
+	i := bytes.LastIndex(b, metadataStartMarker)
+	if i < 0 {
+		return nil, ErrMetadataNotFound
+	}
+	d := &decoder{b: b[i+len(metadataStartMarker):]}
+	v, err := d.decode()
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decode metadata: unexpected type %T", v)
+	}
+
+	md := new(Metadata)
+	md.BinaryFormatMajorVersion = uint(toUint64(m["binary_format_major_version"]))
+	md.BinaryFormatMinorVersion = uint(toUint64(m["binary_format_minor_version"]))
+	if epoch, ok := m["build_epoch"]; ok {
+		md.BuildEpoch = time.Unix(int64(toUint64(epoch)), 0).UTC()
+	}
+	md.DatabaseType, _ = m["database_type"].(string)
+	if description, ok := m["description"].(map[string]interface{}); ok {
+		md.Description = make(map[string]string, len(description))
+		for k, v := range description {
+			md.Description[k], _ = v.(string)
+		}
+	}
+	md.IPVersion = uint(toUint64(m["ip_version"]))
+	if languages, ok := m["languages"].([]interface{}); ok {
+		md.Languages = make([]string, 0, len(languages))
+		for _, l := range languages {
+			if s, ok := l.(string); ok {
+				md.Languages = append(md.Languages, s)
+			}
+		}
+	}
+	md.NodeCount = uint(toUint64(m["node_count"]))
+	md.RecordSize = uint(toUint64(m["record_size"]))
+	return md, nil
+}
+
+// tailBuffer keeps the last size bytes written to it, so that the metadata
+// section can be parsed without reading the written file.
+type tailBuffer struct {
+	b    []byte
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if n >= t.size {
+		t.b = append(t.b[:0], p[n-t.size:]...)
+		return n, nil
+	}
+	t.b = append(t.b, p...)
+	if len(t.b) > t.size {
+		copy(t.b, t.b[len(t.b)-t.size:])
+		t.b = t.b[:t.size]
+	}
+	return n, nil
+}
+
+func toUint64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
+
+// MaxMind DB data section field types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBoolean
+	typeFloat
+)
+
+// decoder decodes values in MaxMind DB data section format. Pointers are
+// not supported as they are not used in the metadata section.
+type decoder struct {
+	b   []byte
+	off int
+}
+
+func (d *decoder) decode() (interface{}, error) {
+	ctrl, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	typ := int(ctrl[0] >> 5)
+	if typ == typeExtended {
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		typ = int(b[0]) + 7
+	}
+	if typ == typePointer {
+		return nil, errors.New("pointers are not supported")
+	}
+	size, err := d.size(int(ctrl[0] & 0x1f))
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case typeString:
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case typeDouble:
+		if size != 8 {
+			return nil, fmt.Errorf("invalid double size %v", size)
+		}
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case typeBytes, typeUint128:
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, fmt.Errorf("invalid unsigned integer size %v", size)
+		}
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, fmt.Errorf("invalid integer size %v", size)
+		}
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), nil
+	case typeMap:
+		// Every map entry takes at least one byte, so the size can not be
+		// larger than the remaining data. This check protects from
+		// allocating large maps for malformed or truncated input.
+		if size > len(d.b)-d.off {
+			return nil, io.ErrUnexpectedEOF
+		}
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid map key type %T", k)
+			}
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	case typeArray:
+		if size > len(d.b)-d.off {
+			return nil, io.ErrUnexpectedEOF
+		}
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case typeBoolean:
+		return size != 0, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, fmt.Errorf("invalid float size %v", size)
+		}
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", typ)
+}
+
+// size decodes the payload size from the first five bits of the control
+// byte and the following bytes.
+func (d *decoder) size(size int) (int, error) {
+	if size < 29 {
+		return size, nil
+	}
+	n := size - 28
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v int
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	switch size {
+	case 29:
+		return 29 + v, nil
+	case 30:
+		return 285 + v, nil
+	}
+	return 65821 + v, nil
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || d.off+n > len(d.b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.b[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestReadMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buildEpoch := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	// long database type exceeds the short string size in the metadata
+	databaseType := "GeoLite2-City" + strings.Repeat("-Long", 60)
+	filename := filepath.Join(dir, "test.mmdb")
+	if err := ioutil.WriteFile(filename, mmdbtest.Database(databaseType, buildEpoch), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadMetadata(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		BinaryFormatMajorVersion: 2,
+		BuildEpoch:               buildEpoch,
+		DatabaseType:             databaseType,
+		Description: map[string]string{
+			"en": "Test " + databaseType + " database",
+		},
+		IPVersion:  6,
+		Languages:  []string{"en"},
+		NodeCount:  1,
+		RecordSize: 24,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got metadata %+v, want %+v", got, want)
+	}
+}
+
+func TestReadMetadata_notFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.mmdb")
+	if err := ioutil.WriteFile(filename, []byte("<html>error</html>"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadMetadata(filename)
+	if !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrMetadataNotFound)
+	}
+}
+
+func TestReadMetadata_oversizedContainer(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "map",
+			data: []byte{0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			name: "array",
+			data: []byte{0x1f, 0x04, 0xff, 0xff, 0xff},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := append(append([]byte("search tree and data section"), metadataStartMarker...), tc.data...)
+			_, err := parseMetadata(b)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+}
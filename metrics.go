@@ -0,0 +1,63 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import "io"
+
+// Metrics records cumulative update statistics, for example with Prometheus
+// counters. Its methods may be called concurrently when multiple editions
+// are updated at the same time.
+type Metrics interface {
+	// Saved is called when the database is downloaded and saved.
+	Saved(editionID string)
+	// Skipped is called when the update is not needed.
+	Skipped(editionID string)
+	// Downloaded is called with the number of bytes that are received while
+	// downloading the archive, also if the download fails.
+	Downloaded(editionID string, bytes int64)
+	// Failed is called with the error when the update fails. Errors can be
+	// classified with errors.Is, for example for ErrChecksumMismatch.
+	Failed(editionID string, err error)
+}
+
+// recordUpdate calls Metrics methods for the update result and error.
+func (u *Updater) recordUpdate(editionID string, result Result, err error) {
+	if u.Metrics == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		u.Metrics.Failed(editionID, err)
+	case result.Saved:
+		u.Metrics.Saved(editionID)
+	default:
+		u.Metrics.Skipped(editionID)
+	}
+}
+
+// countBody returns the reader that counts the bytes read from the response
+// body and the function that reports them to Metrics.
+func (u *Updater) countBody(editionID string, r io.Reader) (io.Reader, func()) {
+	if u.Metrics == nil {
+		return r, func() {}
+	}
+	c := &countReader{r: r}
+	return c, func() {
+		u.Metrics.Downloaded(editionID, c.n)
+	}
+}
+
+// countReader counts the number of bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_Metrics(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	m := new(testMetrics)
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: newMemFileSystem(),
+		Metrics:    m,
+	}
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	for i := 0; i < 2; i++ {
+		if _, err := u.City(context.Background(), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := u.Update(context.Background(), filename, "GeoLite2-Country"); err == nil {
+		t.Fatal("got no error")
+	}
+
+	if m.saved != 1 {
+		t.Errorf("got saved %v, want 1", m.saved)
+	}
+	if m.skipped != 1 {
+		t.Errorf("got skipped %v, want 1", m.skipped)
+	}
+	if want := int64(len(ts.Archive("GeoLite2-City"))); m.bytes != want {
+		t.Errorf("got bytes %v, want %v", m.bytes, want)
+	}
+	if len(m.errs) != 1 || !errors.Is(m.errs[0], ErrEditionNotFound) {
+		t.Errorf("got errors %v, want %v", m.errs, ErrEditionNotFound)
+	}
+}
+
+type testMetrics struct {
+	mu      sync.Mutex
+	saved   int
+	skipped int
+	bytes   int64
+	errs    []error
+}
+
+func (m *testMetrics) Saved(editionID string) {
+	m.mu.Lock()
+	m.saved++
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) Skipped(editionID string) {
+	m.mu.Lock()
+	m.skipped++
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) Downloaded(editionID string, bytes int64) {
+	m.mu.Lock()
+	m.bytes += bytes
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) Failed(editionID string, err error) {
+	m.mu.Lock()
+	m.errs = append(m.errs, err)
+	m.mu.Unlock()
+}
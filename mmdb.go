@@ -4,172 +4,1710 @@
 // license that can be found s the LICENSE file.
 
 // Package mmdb is a Go library for downloading and updating
-// MaxMind GeoLite2 databases.
+// MaxMind GeoLite2 and GeoIP2 databases.
 //
 // Functions will download tar archive, extract the database file from it
-// to a provided file name, and save MD5 sum of tar archive in a file
+// to a temporary file that is renamed to a provided file name only when it
+// is completely written and validated, and save MD5 sum of tar archive in a file
 // in the same directory as the database file. MD5 sum is used for checking
 // if the database is updated on the next function call.
 package mmdb
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-// GeoLite2 download URLs.
+// DefaultBaseURL is the MaxMind endpoint for downloading databases.
+const DefaultBaseURL = "https://download.maxmind.com/app/geoip_download"
+
+// GeoLite2 edition IDs.
 var (
-	geoLite2CityURL    = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-City"
-	geoLite2CountryURL = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-Country"
-	geoLite2ASNURL     = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-ASN"
+	geoLite2CityEditionID    = "GeoLite2-City"
+	geoLite2CountryEditionID = "GeoLite2-Country"
+	geoLite2ASNEditionID     = "GeoLite2-ASN"
 )
 
-// GeoLite2 database filenames inside tar archives.
+// GeoIP2 edition IDs.
 var (
-	geoLite2CityFilename    = "GeoLite2-City.mmdb"
-	geoLite2CountryFilename = "GeoLite2-Country.mmdb"
-	geoLite2ASNFilename     = "GeoLite2-ASN.mmdb"
+	geoIP2CityEditionID    = "GeoIP2-City"
+	geoIP2CountryEditionID = "GeoIP2-Country"
+	geoIP2ISPEditionID     = "GeoIP2-ISP"
+)
+
+// ErrChecksumMismatch is returned when MD5 sum of the downloaded tar archive
+// is not the same as the published one.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Result holds information about the database update.
+type Result struct {
+	// Edition is the edition ID of the database.
+	Edition string
+	// Saved is true if the database is downloaded and saved.
+	Saved bool
+	// FromCache is true if the database is not downloaded, as the already
+	// saved one is up to date.
+	FromCache bool
+	// FirstDownload is true if the database is saved and there was no
+	// database saved under the filename before.
+	FirstDownload bool
+	// Bytes is the size of the saved database.
+	Bytes int64
+	// MD5 is the published MD5 sum of the tar archive, saved for update
+	// checks. It is empty if the remote responded with Not Modified.
+	MD5 string
+	// BuildEpoch is the build time of the saved database from its metadata,
+	// also if the database is not updated. It is zero if the database or its
+	// metadata can not be read.
+	BuildEpoch time.Time
+	// Header holds the HTTP response header of the tar archive download,
+	// like Last-Modified and ETag. It is nil if the archive is not
+	// downloaded.
+	Header http.Header
+}
+
+// Updater downloads and updates MaxMind databases. Zero value is usable, but
+// LicenseKey should be set for downloads from MaxMind.
+type Updater struct {
+	// LicenseKey is the MaxMind license key. Surrounding whitespace is
+	// ignored. It is required for downloads from the default endpoint,
+	// without BaseURL or BaseURLs, and ErrNoLicenseKey is returned, without
+	// any requests, if it is empty.
+	LicenseKey string
+	// LicenseKeyFunc returns the license key for every request, for
+	// example from a secret manager. If it is set, LicenseKey is not used.
+	LicenseKeyFunc func() (string, error)
+	// AccountID is the MaxMind account ID. If it is set, credentials are sent
+	// with HTTP Basic Auth instead of the license_key query parameter.
+	AccountID string
+	// Client is used for HTTP requests. If nil, http.DefaultClient is used,
+	// which follows up to 10 redirects and uses proxies from HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables, as do the clients
+	// returned by functions in this package. Custom clients must follow
+	// redirects, as downloads are redirected, or ErrRedirectNotFollowed is
+	// returned. Custom http.RoundTripper can be set as its Transport.
+	// NewLocalAddrClient returns a client that makes connections from a
+	// specific local address and NewKeepAliveClient a client with tuned
+	// reuse of connections. Proxy credentials can be set in the proxy URL
+	// userinfo and they are sent in the Proxy-Authorization header.
+	Client *http.Client
+	// RequestFunc is called with every HTTP request before it is sent, for
+	// example to add custom headers.
+	RequestFunc func(r *http.Request)
+	// BaseURL is the download endpoint. If empty, DefaultBaseURL is used.
+	BaseURL string
+	// BaseURLs are download endpoints, like mirrors, that are tried in
+	// order, moving to the next one on a network error or a server error
+	// response. If set, BaseURL is not used.
+	BaseURLs []string
+	// QueryParams are added to the query of every request to the download
+	// endpoint, for example a token required by a mirror. The edition_id,
+	// license_key and suffix parameters can not be changed, and the
+	// license_key parameter is not sent if the AccountID is set.
+	QueryParams url.Values
+	// Retries is the number of times a request is retried after a network
+	// error or a server error response. Client error responses, like
+	// Unauthorized for invalid license key, are not retried.
+	Retries int
+	// RetryBackoff is the time to wait before the first retry. It is doubled
+	// for every next one. If zero, DefaultRetryBackoff is used.
+	RetryBackoff time.Duration
+	// Concurrency is the maximal number of databases updated at the same
+	// time by UpdateEditions. If zero, DefaultConcurrency is used.
+	Concurrency int
+	// DatabaseFilename holds names of database files in tar archives, keyed
+	// by the edition ID, matched in any directory of the archive, ignoring
+	// the case. For editions that are not in the map, edition ID with .mmdb
+	// extension is used, as in MaxMind archives.
+	DatabaseFilename map[string]string
+	// Force disables update checks, so that the database is always
+	// downloaded and saved, together with the new state file.
+	Force bool
+	// MinInterval is the minimal age of the saved database, by its
+	// modification time, for the update to check if a newer one is
+	// available. Younger databases are not updated without any requests.
+	MinInterval time.Duration
+	// IfModifiedSince enables sending conditional requests with
+	// If-Modified-Since header set to the modification time of the existing
+	// database file. Not Modified response is handled as no update
+	// available, without comparing MD5 sums.
+	IfModifiedSince bool
+	// UserAgent is the User-Agent header value sent with requests. If empty,
+	// DefaultUserAgent is used.
+	UserAgent string
+	// StateDir is the directory where MD5 sum files are saved. If empty,
+	// they are saved in the same directory as the database file.
+	StateDir string
+	// SHA256 enables saving SHA256 sums in the state file instead of the
+	// published MD5 sum of the tar archive. The state file is named by the
+	// edition ID with .sha256 extension. It contains the SHA256 sum of the
+	// published MD5 sum, that is used for update checks, and the SHA256 sum
+	// of the extracted database. The MD5 sum file from a previous update is
+	// used for the first check and removed after the database is saved.
+	SHA256 bool
+	// Stateless disables reading and writing of state files, so that only
+	// the database file is written. Without the state, the database is
+	// downloaded on every update, unless PreviousMD5 has the edition, and
+	// Resume option is ignored.
+	Stateless bool
+	// RemoteSHA256 verifies downloaded archives with their published SHA256
+	// sums, requested with the .sha256 suffix, instead of the MD5 sums. If
+	// the SHA256 sum is not published, the MD5 sum is used. The SHA256 sum is
+	// saved in the MD5 sum file and returned as the Result MD5.
+	RemoteSHA256 bool
+	// PreviousMD5 holds MD5 sums of archives from previous updates, as
+	// returned in the Result, keyed by the edition ID, that are used for
+	// update checks instead of the ones from state files. With Stateless
+	// option, it allows keeping the state outside of the filesystem, by
+	// saving the Result MD5 of every edition. It is the only update check
+	// done by UpdateFile.
+	PreviousMD5 map[string]string
+	// FileMode is the permission mode for created database and MD5 sum
+	// files, before umask. If zero, DefaultFileMode is used.
+	FileMode os.FileMode
+	// DirMode is the permission mode for created directories, before umask.
+	// If zero, DefaultDirMode is used.
+	DirMode os.FileMode
+	// FileSystem is used for saving files. If nil, the operating system
+	// filesystem is used.
+	FileSystem FileSystem
+	// Timeout limits the duration of each request attempt, including
+	// reading of the response body. The earlier deadline of the timeout and
+	// the context passed to methods is used. If zero, there is no timeout.
+	Timeout time.Duration
+	// Deadline, if it is not zero, is the time by which all requests of
+	// every operation, including retries and reading of response bodies, must
+	// complete, as an absolute limit for the whole update, regardless of the
+	// Timeout and the context passed to methods.
+	Deadline time.Time
+	// GzipOutput enables writing the database compressed with gzip under
+	// filename. The size in the Result is the size of the uncompressed
+	// database.
+	GzipOutput bool
+	// ArchiveDir is the directory where the downloaded archive of every
+	// edition is saved together with the database, exactly as it is
+	// received, so that it can be verified later with the MD5 sum from the
+	// state file. The archive is saved under the edition ID with the
+	// download suffix, like GeoLite2-City.tar.gz, and it is replaced on
+	// every update that saves the database, before the database is
+	// replaced.
+	ArchiveDir string
+	// AcceptFunc is called with the metadata of the downloaded database and
+	// of the existing one, or nil if there is none or it can not be read,
+	// before the existing database is replaced, for example to reject
+	// databases with older build epochs. If it returns false, the downloaded
+	// database is discarded without an error and the state is not saved, so
+	// that the database is downloaded again by the next update.
+	AcceptFunc func(newMetadata, oldMetadata *Metadata) bool
+	// AuxiliaryFiles are names of files from the tar archive, matched in the
+	// same way as the database file, that are saved in the database
+	// directory together with the database, like COPYRIGHT.txt and
+	// LICENSE.txt from MaxMind archives. Files that are not in the archive
+	// are ignored.
+	AuxiliaryFiles []string
+	// Backups is the number of previous database versions that are kept
+	// when the database is updated, saved under the database filename with
+	// the generation number extension, .1 for the most recent one.
+	Backups int
+	// BackupName, if set, returns the name of the backup of the database
+	// saved under filename, instead of the generation number extension, for
+	// example with the date of the database build time t. Names of backups
+	// are kept in a file under the database filename with the .backups
+	// extension, so that ones beyond the number of Backups are removed.
+	BackupName func(filename string, t time.Time) string
+	// FileLock enables an advisory lock on the file under the database
+	// filename with the .lock extension during updates, so that updates of
+	// the same database from multiple processes are serialized, as they are
+	// within the process. It is used only with the operating system
+	// filesystem and the lock file is not removed.
+	FileLock bool
+	// Jitter is the maximal random delay of updates by Run, so that many
+	// instances started at the same time do not send requests at the same
+	// time. It is added to the update time on every interval.
+	Jitter time.Duration
+	// SkipDiskSpaceCheck disables checking if there is enough free disk
+	// space for the database before the archive is downloaded. The check is
+	// done only for the operating system filesystem and when the archive size
+	// is known.
+	SkipDiskSpaceCheck bool
+	// Resume enables resumable downloads. The tar archive is downloaded to a
+	// partial file in the state directory and, if the download is
+	// interrupted, it is continued with a Range request from the last
+	// received byte, within the same update on retries or by the next update
+	// if the published MD5 sum is not changed. The database is extracted only
+	// from the complete archive.
+	Resume bool
+	// Probe enables a HEAD request for the tar archive before it is
+	// downloaded, so that its size is known for the disk space check before
+	// the download starts. If the download response has no Content-Length,
+	// but it has the same ETag as the probe response, the size from the
+	// probe is used for the progress and for the completeness check. The
+	// ETag is not saved and it is not used to decide if the database is
+	// updated, which is still done only with the published MD5 sum. If the
+	// server does not support HEAD requests, the archive is downloaded
+	// without the probe. The probe is not made with Resume option.
+	Probe bool
+	// Preallocate enables setting the size of the database file to the
+	// size of the database in the tar archive before it is written, which
+	// may reduce the file fragmentation for large databases. It is supported
+	// by the operating system filesystem and not used with GzipOutput.
+	Preallocate bool
+	// BufferSize is the size of the buffer used for reading and extracting
+	// the archive. If it is not set, default buffer sizes are used.
+	BufferSize int
+	// Suffix is the download suffix of the database archive that also
+	// determines how the database is extracted. Supported suffixes are
+	// "tar.gz", the default one, "tar" for uncompressed tar archives and
+	// "mmdb" for database files that are not archived, as they may be
+	// served by custom mirrors.
+	Suffix string
+	// Hooks are called on update events.
+	Hooks Hooks
+	// Metrics records update statistics.
+	Metrics Metrics
+	// Progress is called while the tar archive is downloaded with the number
+	// of bytes downloaded so far and the total size from the Content-Length
+	// header, or -1 if it is not known.
+	Progress func(bytesDownloaded, totalBytes int64)
+}
+
+// Download suffixes with the corresponding extraction of the database.
+const (
+	// tarGzSuffix is the download suffix of gzipped tar archives, as
+	// published by MaxMind.
+	tarGzSuffix = "tar.gz"
+	// tarSuffix is the download suffix of uncompressed tar archives.
+	tarSuffix = "tar"
+	// mmdbSuffix is the download suffix of database files that are not
+	// archived.
+	mmdbSuffix = "mmdb"
+)
+
+// suffix returns the download suffix of the database archive.
+func (u *Updater) suffix() string {
+	if u.Suffix != "" {
+		return u.Suffix
+	}
+	return tarGzSuffix
+}
+
+// Version is the version of this package, without the v prefix of its
+// release tag. It is bumped in the commit that is tagged as a release, so
+// that the tag v0.2.0 holds Version 0.2.0, and it stays the same until the
+// next release.
+const Version = "0.2.0"
+
+// DefaultUserAgent is the default User-Agent header value, with the package
+// version.
+const DefaultUserAgent = "janos-mmdb/" + Version
+
+// Default permission modes for created files and directories.
+const (
+	DefaultFileMode os.FileMode = 0666
+	DefaultDirMode  os.FileMode = 0777
 )
 
+// DefaultRetryBackoff is the default time to wait before the first retry.
+const DefaultRetryBackoff = time.Second
+
+// Update downloads and updates a database of the provided MaxMind edition
+// and saves it under filename. The database file in the tar archive is
+// expected to be named as edition ID with .mmdb extension, unless
+// DatabaseFilename is set for the edition. MD5 sum of the tar archive is saved in a file in
+// the same directory for update checks.
+func (u *Updater) Update(ctx context.Context, filename, editionID string) (Result, error) {
+	return u.update(ctx, filename, editionID, u.dbname(editionID))
+}
+
+// fileSystem returns the configured FileSystem or the operating system
+// filesystem.
+func (u *Updater) fileSystem() FileSystem {
+	if u.FileSystem != nil {
+		return u.FileSystem
+	}
+	return osFileSystem{}
+}
+
+func (u *Updater) fileMode() os.FileMode {
+	if u.FileMode != 0 {
+		return u.FileMode
+	}
+	return DefaultFileMode
+}
+
+func (u *Updater) dirMode() os.FileMode {
+	if u.DirMode != 0 {
+		return u.DirMode
+	}
+	return DefaultDirMode
+}
+
+// dbname returns the name of the database file in the tar archive.
+func (u *Updater) dbname(editionID string) string {
+	if name := u.DatabaseFilename[editionID]; name != "" {
+		return name
+	}
+	return editionFilename(editionID)
+}
+
+// Download downloads a database of the provided MaxMind edition and writes
+// it to the writer, without saving any files. MD5 sum of the tar archive and
+// the database metadata are verified only after the database is written. If
+// ErrChecksumMismatch or ErrInvalidDatabase is returned, the written data
+// should be discarded.
+func (u *Updater) Download(ctx context.Context, w io.Writer, editionID string) error {
+	checksum, err := u.remoteChecksum(ctx, editionID, u.suffix(), time.Time{})
+	if err != nil {
+		return err
+	}
+	_, _, err = u.download(ctx, w, nil, editionID, u.dbname(editionID), checksum, nil)
+	return err
+}
+
+// DownloadBytes downloads a database of the provided MaxMind edition and
+// returns its content, together with the MD5 sum of the tar archive, without
+// saving any files. The whole database is held in memory.
+func (u *Updater) DownloadBytes(ctx context.Context, editionID string) (db []byte, md5sum string, err error) {
+	checksum, err := u.remoteChecksum(ctx, editionID, u.suffix(), time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if _, _, err := u.download(ctx, &buf, nil, editionID, u.dbname(editionID), checksum, nil); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), string(checksum), nil
+}
+
+// UpdateFile downloads a database of the provided MaxMind edition and writes
+// it to the already opened file, replacing its content, for environments
+// where files can not be opened by name. No state files are read or saved.
+// The update is skipped if PreviousMD5 of the edition is the same as the MD5
+// sum of the current tar archive, which is returned in the Result for the
+// next update. As the file is written in place, its content should be
+// discarded if an error is returned.
+func (u *Updater) UpdateFile(ctx context.Context, f *os.File, editionID string) (result Result, err error) {
+	defer func() { u.recordUpdate(editionID, result, err) }()
+	defer func() {
+		if err != nil {
+			err = &EditionError{EditionID: editionID, Err: err}
+		}
+	}()
+
+	checksum, err := u.remoteChecksum(ctx, editionID, u.suffix(), time.Time{})
+	if err != nil {
+		return result, err
+	}
+	result.Edition = editionID
+	result.MD5 = string(checksum)
+	if previous := u.previousMD5(editionID); !u.Force && previous != nil && bytes.Equal(previous, checksum) {
+		result.FromCache = true
+		u.Hooks.skip(editionID)
+		return result, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Result{}, fmt.Errorf("seek db file: %w", err)
+	}
+	if err := f.Truncate(0); err != nil {
+		return Result{}, fmt.Errorf("truncate db file: %w", err)
+	}
+
+	start := time.Now()
+	md, n, err := u.download(ctx, f, nil, editionID, u.dbname(editionID), checksum, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return Result{}, fmt.Errorf("sync db file: %w", err)
+	}
+	result.Saved = true
+	result.Bytes = n
+	result.BuildEpoch = md.BuildEpoch
+
+	u.Hooks.downloadComplete(editionID, n, time.Since(start))
+
+	return result, nil
+}
+
+// Extract reads the tar.gz archive from the reader and writes the content
+// of the database file named dbname in the archive to the writer. The
+// database file is matched in the same way as with Update, regardless of the
+// directory in the archive. ErrDatabaseNotInArchive is returned if the
+// database file is not in the archive and ErrInvalidDatabase if it is not a
+// valid MaxMind DB database, in which case the written data should be
+// discarded.
+func Extract(r io.Reader, dbname string, w io.Writer) error {
+	_, _, err := (&Updater{}).extract(r, w, tarGzSuffix, dbname, nil)
+	return err
+}
+
+// RemoteMD5 returns the published MD5 sum of the tar archive of the provided
+// MaxMind edition, without reading or writing any files.
+func (u *Updater) RemoteMD5(ctx context.Context, editionID string) (string, error) {
+	checksum, err := u.remoteMD5(ctx, editionID, u.suffix(), time.Time{})
+	if err != nil {
+		return "", err
+	}
+	return string(checksum), nil
+}
+
+// ErrInvalidChecksum is returned when the published MD5 sum of the archive
+// is not a valid hex encoded MD5 sum, as when the server responds with an
+// error page.
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
+// ErrIncompleteDownload is returned when fewer bytes are received than
+// announced by the Content-Length response header.
+var ErrIncompleteDownload = errors.New("incomplete download")
+
+// ErrDatabaseNotInArchive is returned when the database file is not found in
+// the downloaded archive.
+var ErrDatabaseNotInArchive = errors.New("database not in archive")
+
+// ErrBuildDateNotFound is returned when the build date can not be found in
+// the directory name of the tar archive.
+var ErrBuildDateNotFound = errors.New("build date not found")
+
+// RemoteBuildDate returns the build date of the database in the tar archive
+// of the provided MaxMind edition, parsed from the name of the directory in
+// the archive, like GeoLite2-City_20200102. Only the beginning of the archive
+// is downloaded, until the first entry is read.
+func (u *Updater) RemoteBuildDate(ctx context.Context, editionID string) (time.Time, error) {
+	r, err := u.get(ctx, editionID, tarGzSuffix, time.Time{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get tar: %w", err)
+	}
+	defer r.Body.Close()
+
+	gzr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("gzip reader: %w", err)
+	}
+	header, err := tar.NewReader(gzr).Next()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read tar: %w", err)
+	}
+	return parseBuildDate(header.Name)
+}
+
+// parseBuildDate returns the date from the suffix of the first directory in
+// the tar archive entry name.
+func parseBuildDate(name string) (time.Time, error) {
+	dir := strings.ReplaceAll(name, "\\", "/")
+	if i := strings.IndexByte(dir, '/'); i >= 0 {
+		dir = dir[:i]
+	}
+	i := strings.LastIndexByte(dir, '_')
+	if i < 0 {
+		return time.Time{}, ErrBuildDateNotFound
+	}
+	t, err := time.Parse("20060102", dir[i+1:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrBuildDateNotFound, err)
+	}
+	return t, nil
+}
+
+// Check reports whether a newer database of the provided MaxMind edition is
+// available than the one saved under filename, without downloading it.
+func (u *Updater) Check(ctx context.Context, filename, editionID string) (updateAvailable bool, err error) {
+	_, updateAvailable, err = u.check(ctx, filename, editionID, u.suffix())
+	return updateAvailable, err
+}
+
+// Country downloads and updates a GeoLite2 Country database and saves it
+// under filename. MD5 sum of the tar archive is saved in a file in the same
+// directory for update checks.
+func (u *Updater) Country(ctx context.Context, filename string) (Result, error) {
+	return u.Update(ctx, filename, geoLite2CountryEditionID)
+}
+
+// City downloads and updates a GeoLite2 City database and saves it
+// under filename. MD5 sum of the tar archive is saved in a file in the same
+// directory for update checks.
+func (u *Updater) City(ctx context.Context, filename string) (Result, error) {
+	return u.Update(ctx, filename, geoLite2CityEditionID)
+}
+
+// ASN downloads and updates a GeoLite2 ASN database and saves it
+// under filename. MD5 sum of the tar archive is saved in a file in the same
+// directory for update checks.
+func (u *Updater) ASN(ctx context.Context, filename string) (Result, error) {
+	return u.Update(ctx, filename, geoLite2ASNEditionID)
+}
+
+// UpdateEdition downloads and updates a database of the provided MaxMind
+// edition and saves it under filename. The database file in the tar archive
+// is expected to be named as edition ID with .mmdb extension. MD5 sum of the
+// tar archive is saved in a file in the same directory for update checks.
+func UpdateEdition(ctx context.Context, filename, editionID, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).Update(ctx, filename, editionID)
+	return r.Saved, err
+}
+
+// WriteEdition downloads a database of the provided MaxMind edition and
+// writes it to the writer, without saving any files on the filesystem.
+func WriteEdition(ctx context.Context, w io.Writer, editionID, licenseKey string) error {
+	return (&Updater{LicenseKey: licenseKey}).Download(ctx, w, editionID)
+}
+
+// DownloadBytes downloads a database of the provided MaxMind edition and
+// returns its content and the MD5 sum of the tar archive, without saving any
+// files on the filesystem.
+func DownloadBytes(ctx context.Context, editionID, licenseKey string) (db []byte, md5sum string, err error) {
+	return (&Updater{LicenseKey: licenseKey}).DownloadBytes(ctx, editionID)
+}
+
+// RemoteMD5 returns the published MD5 sum of the tar archive of the provided
+// MaxMind edition, without reading or writing any files.
+func RemoteMD5(ctx context.Context, editionID, licenseKey string) (string, error) {
+	return (&Updater{LicenseKey: licenseKey}).RemoteMD5(ctx, editionID)
+}
+
+// RemoteBuildDate returns the build date of the database in the tar archive
+// of the provided MaxMind edition, downloading only the beginning of the
+// archive.
+func RemoteBuildDate(ctx context.Context, editionID, licenseKey string) (time.Time, error) {
+	return (&Updater{LicenseKey: licenseKey}).RemoteBuildDate(ctx, editionID)
+}
+
 // UpdateGeoLite2Country downloads and updates a GeoLite2 Country database and saves it
 // under filename. MD5 sum of the tar archive is saved in a file in the same directory
 // for update checks.
 func UpdateGeoLite2Country(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
-	return update(ctx, filename, geoLite2CountryFilename, geoLite2CountryURL, licenseKey)
+	return UpdateEdition(ctx, filename, geoLite2CountryEditionID, licenseKey)
 }
 
 // UpdateGeoLite2City downloads and updates a GeoLite2 City database and saves it
 // under filename. MD5 sum of the tar archive is saved in a file in the same directory
 // for update checks.
 func UpdateGeoLite2City(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
-	return update(ctx, filename, geoLite2CityFilename, geoLite2CityURL, licenseKey)
+	return UpdateEdition(ctx, filename, geoLite2CityEditionID, licenseKey)
 }
 
 // UpdateGeoLite2ASN downloads and updates a GeoLite2 ASN database and saves it
 // under filename. MD5 sum of the tar archive is saved in a file in the same directory
 // for update checks.
 func UpdateGeoLite2ASN(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
-	return update(ctx, filename, geoLite2ASNFilename, geoLite2ASNURL, licenseKey)
+	return UpdateEdition(ctx, filename, geoLite2ASNEditionID, licenseKey)
+}
+
+// UpdateEditionTimeout is the same as UpdateEdition, but without the context
+// argument. The update is cancelled if it is not done within the timeout.
+func UpdateEditionTimeout(filename, editionID, licenseKey string, timeout time.Duration) (saved bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return UpdateEdition(ctx, filename, editionID, licenseKey)
 }
 
-func update(ctx context.Context, filename, dbname, address, licenseKey string) (saved bool, err error) {
-	u, err := url.Parse(address)
+// UpdateGeoLite2CountryTimeout downloads and updates a GeoLite2 Country
+// database and saves it under filename, cancelling the update if it is not
+// done within the timeout.
+func UpdateGeoLite2CountryTimeout(filename, licenseKey string, timeout time.Duration) (saved bool, err error) {
+	return UpdateEditionTimeout(filename, geoLite2CountryEditionID, licenseKey, timeout)
+}
+
+// UpdateGeoLite2CityTimeout downloads and updates a GeoLite2 City database
+// and saves it under filename, cancelling the update if it is not done
+// within the timeout.
+func UpdateGeoLite2CityTimeout(filename, licenseKey string, timeout time.Duration) (saved bool, err error) {
+	return UpdateEditionTimeout(filename, geoLite2CityEditionID, licenseKey, timeout)
+}
+
+// UpdateGeoLite2ASNTimeout downloads and updates a GeoLite2 ASN database and
+// saves it under filename, cancelling the update if it is not done within
+// the timeout.
+func UpdateGeoLite2ASNTimeout(filename, licenseKey string, timeout time.Duration) (saved bool, err error) {
+	return UpdateEditionTimeout(filename, geoLite2ASNEditionID, licenseKey, timeout)
+}
+
+// UpdateGeoLite2CountryToDir downloads and updates a GeoLite2 Country
+// database and saves it in the directory as GeoLite2-Country.mmdb.
+func UpdateGeoLite2CountryToDir(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateToDir(ctx, dir, geoLite2CountryEditionID)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2CityToDir downloads and updates a GeoLite2 City database and
+// saves it in the directory as GeoLite2-City.mmdb.
+func UpdateGeoLite2CityToDir(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateToDir(ctx, dir, geoLite2CityEditionID)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2ASNToDir downloads and updates a GeoLite2 ASN database and
+// saves it in the directory as GeoLite2-ASN.mmdb.
+func UpdateGeoLite2ASNToDir(ctx context.Context, dir, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{LicenseKey: licenseKey}).UpdateToDir(ctx, dir, geoLite2ASNEditionID)
+	return r.Saved, err
+}
+
+// UpdateGeoIP2Country downloads and updates a GeoIP2 Country database and
+// saves it under filename. MD5 sum of the tar archive is saved in a file in
+// the same directory for update checks.
+func UpdateGeoIP2Country(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
+	return UpdateEdition(ctx, filename, geoIP2CountryEditionID, licenseKey)
+}
+
+// UpdateGeoIP2City downloads and updates a GeoIP2 City database and saves
+// it under filename. MD5 sum of the tar archive is saved in a file in the
+// same directory for update checks.
+func UpdateGeoIP2City(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
+	return UpdateEdition(ctx, filename, geoIP2CityEditionID, licenseKey)
+}
+
+// UpdateGeoIP2ISP downloads and updates a GeoIP2 ISP database and saves it
+// under filename. MD5 sum of the tar archive is saved in a file in the same
+// directory for update checks.
+func UpdateGeoIP2ISP(ctx context.Context, filename, licenseKey string) (saved bool, err error) {
+	return UpdateEdition(ctx, filename, geoIP2ISPEditionID, licenseKey)
+}
+
+// UpdateGeoLite2CountryWithAccount downloads and updates a GeoLite2 Country
+// database in the same way as UpdateGeoLite2Country, but authenticates with
+// HTTP Basic Auth using MaxMind account ID and license key.
+func UpdateGeoLite2CountryWithAccount(ctx context.Context, filename, accountID, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{AccountID: accountID, LicenseKey: licenseKey}).Country(ctx, filename)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2CityWithAccount downloads and updates a GeoLite2 City
+// database in the same way as UpdateGeoLite2City, but authenticates with
+// HTTP Basic Auth using MaxMind account ID and license key.
+func UpdateGeoLite2CityWithAccount(ctx context.Context, filename, accountID, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{AccountID: accountID, LicenseKey: licenseKey}).City(ctx, filename)
+	return r.Saved, err
+}
+
+// UpdateGeoLite2ASNWithAccount downloads and updates a GeoLite2 ASN
+// database in the same way as UpdateGeoLite2ASN, but authenticates with
+// HTTP Basic Auth using MaxMind account ID and license key.
+func UpdateGeoLite2ASNWithAccount(ctx context.Context, filename, accountID, licenseKey string) (saved bool, err error) {
+	r, err := (&Updater{AccountID: accountID, LicenseKey: licenseKey}).ASN(ctx, filename)
+	return r.Saved, err
+}
+
+// CheckCountry reports whether a newer GeoLite2 Country database is
+// available than the one saved under filename, without downloading it.
+func (u *Updater) CheckCountry(ctx context.Context, filename string) (updateAvailable bool, err error) {
+	return u.Check(ctx, filename, geoLite2CountryEditionID)
+}
+
+// CheckCity reports whether a newer GeoLite2 City database is available
+// than the one saved under filename, without downloading it.
+func (u *Updater) CheckCity(ctx context.Context, filename string) (updateAvailable bool, err error) {
+	return u.Check(ctx, filename, geoLite2CityEditionID)
+}
+
+// CheckASN reports whether a newer GeoLite2 ASN database is available than
+// the one saved under filename, without downloading it.
+func (u *Updater) CheckASN(ctx context.Context, filename string) (updateAvailable bool, err error) {
+	return u.Check(ctx, filename, geoLite2ASNEditionID)
+}
+
+// CheckGeoLite2Country reports whether a newer GeoLite2 Country database is
+// available than the one saved under filename. Only the MD5 sum of the tar
+// archive is downloaded and compared with the one from the previous update.
+func CheckGeoLite2Country(ctx context.Context, filename, licenseKey string) (updateAvailable bool, err error) {
+	return (&Updater{LicenseKey: licenseKey}).CheckCountry(ctx, filename)
+}
+
+// CheckGeoLite2City reports whether a newer GeoLite2 City database is
+// available than the one saved under filename. Only the MD5 sum of the tar
+// archive is downloaded and compared with the one from the previous update.
+func CheckGeoLite2City(ctx context.Context, filename, licenseKey string) (updateAvailable bool, err error) {
+	return (&Updater{LicenseKey: licenseKey}).CheckCity(ctx, filename)
+}
+
+// CheckGeoLite2ASN reports whether a newer GeoLite2 ASN database is
+// available than the one saved under filename. Only the MD5 sum of the tar
+// archive is downloaded and compared with the one from the previous update.
+func CheckGeoLite2ASN(ctx context.Context, filename, licenseKey string) (updateAvailable bool, err error) {
+	return (&Updater{LicenseKey: licenseKey}).CheckASN(ctx, filename)
+}
+
+func (u *Updater) update(ctx context.Context, filename, editionID, dbname string) (result Result, err error) {
+	defer func() { u.recordUpdate(editionID, result, err) }()
+	defer func() {
+		if err != nil {
+			err = &EditionError{EditionID: editionID, Err: err}
+		}
+	}()
+
+	fs := u.fileSystem()
+
+	if err := checkDestination(fs, filename); err != nil {
+		return result, err
+	}
+	// the directory is created before any request, so that permission
+	// problems are reported without downloading
+	if err := fs.MkdirAll(filepath.Dir(filename), u.dirMode()); err != nil {
+		return result, fmt.Errorf("create directory: %w", err)
+	}
+
+	// concurrent updates of the same database are serialized, so that the
+	// next one finds the state saved by the previous one
+	unlock, err := u.lock(fs, filename)
 	if err != nil {
-		return false, err
+		return result, err
+	}
+	defer unlock()
+
+	var checksum []byte
+	var updateAvailable bool
+	if !u.fresh(fs, filename) {
+		checksum, updateAvailable, err = u.check(ctx, filename, editionID, u.suffix())
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if !updateAvailable {
+		if checksum != nil {
+			if err := u.migrateLegacyState(fs, filename, editionID, checksum); err != nil {
+				return result, err
+			}
+		}
+		result.Edition = editionID
+		result.FromCache = true
+		result.MD5 = string(checksum)
+		if md, err := u.readMetadata(fs, filename); err == nil {
+			result.BuildEpoch = md.BuildEpoch
+		}
+		u.Hooks.skip(editionID)
+		return result, nil
+	}
+
+	if err := probeWritable(fs, filename); err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	result, dbSum, err := u.saveEdition(ctx, fs, filename, editionID, dbname, checksum)
+	if errors.Is(err, ErrChecksumMismatch) {
+		// the md5 file may be cached separately from the archive and be
+		// stale, so the download is retried once with a newer checksum
+		newChecksum, updateAvailable, cerr := u.check(ctx, filename, editionID, u.suffix())
+		if cerr == nil && updateAvailable && !bytes.Equal(newChecksum, checksum) {
+			checksum = newChecksum
+			result, dbSum, err = u.saveEdition(ctx, fs, filename, editionID, dbname, checksum)
+		}
 	}
-	q := u.Query()
-	q.Set("license_key", licenseKey)
-	q.Set("suffix", "tar.gz.md5")
-	u.RawQuery = q.Encode()
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
-		return false, fmt.Errorf("http request md5 file: %w", err)
+		return Result{}, err
 	}
-	if ctx != nil {
-		req = req.WithContext(ctx)
+	result.Edition = editionID
+	if !result.Saved {
+		// the database is not accepted
+		u.Hooks.skip(editionID)
+		return result, nil
+	}
+	result.MD5 = string(checksum)
+
+	if err := u.writeState(fs, filename, editionID, u.suffix(), checksum, dbSum); err != nil {
+		return result, err
+	}
+
+	u.Hooks.downloadComplete(editionID, result.Bytes, time.Since(start))
+
+	return result, nil
+}
+
+// checkDestination returns ErrDestinationIsDirectory if the database
+// filename is an existing directory, before anything is downloaded.
+func checkDestination(fs FileSystem, filename string) error {
+	if info, err := fs.Stat(filename); err == nil && info.IsDir() {
+		return fmt.Errorf("%s: %w", filename, ErrDestinationIsDirectory)
 	}
-	r, err := http.DefaultClient.Do(req)
+	return nil
+}
+
+// probeWritable creates and removes the temporary file of the database
+// saved under filename, to report that the directory is not writable before
+// the archive is downloaded.
+func probeWritable(fs FileSystem, filename string) error {
+	tmpFilename := filename + ".tmp"
+	f, err := fs.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return false, fmt.Errorf("get md5 file: %w", err)
+		return fmt.Errorf("directory not writable: %w", err)
 	}
-	defer r.Body.Close()
-	if r.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected http response %s", r.Status)
+	_ = f.Close()
+	_ = fs.Remove(tmpFilename)
+	return nil
+}
+
+// saveEdition downloads the archive of the edition with the expected
+// checksum and saves the database under filename. With ArchiveDir option,
+// the archive is written to a temporary file that is renamed before the
+// database is replaced.
+func (u *Updater) saveEdition(ctx context.Context, fs FileSystem, filename, editionID, dbname string, checksum []byte) (result Result, dbSum []byte, err error) {
+	var archive File
+	var replace func() error
+	if u.ArchiveDir != "" {
+		archiveFilename := u.archiveFilename(editionID)
+		archive, err = u.createArchive(fs, archiveFilename)
+		if err != nil {
+			return Result{}, nil, err
+		}
+		closed := false
+		closeArchive := func() error {
+			if closed {
+				return nil
+			}
+			closed = true
+			return archive.Close()
+		}
+		defer func() {
+			_ = closeArchive()
+			// the temporary file is already renamed if the update succeeds
+			_ = fs.Remove(archiveFilename + ".tmp")
+		}()
+		replace = func() error {
+			if err := closeArchive(); err != nil {
+				return fmt.Errorf("close archive file: %w", err)
+			}
+			if err := fs.Rename(archiveFilename+".tmp", archiveFilename); err != nil {
+				return fmt.Errorf("rename archive file: %w", err)
+			}
+			return nil
+		}
+	}
+
+	download := func(w io.Writer, preflight func(header http.Header, size int64) error) (*Metadata, int64, error) {
+		return u.download(ctx, w, archive, editionID, dbname, checksum, preflight)
+	}
+	if u.Resume && !u.Stateless {
+		download = func(w io.Writer, preflight func(header http.Header, size int64) error) (*Metadata, int64, error) {
+			return u.downloadResumable(ctx, fs, u.partFilename(filename, editionID), w, archive, editionID, dbname, checksum, preflight)
+		}
 	}
+	return u.save(fs, filename, download, replace)
+}
 
-	md5, err := ioutil.ReadAll(r.Body)
+// archiveFilename returns the name of the file in the ArchiveDir where the
+// archive of the edition is saved.
+func (u *Updater) archiveFilename(editionID string) string {
+	return filepath.Join(u.ArchiveDir, editionID+"."+u.suffix())
+}
+
+// createArchive creates the temporary file where the downloaded archive is
+// written before it is renamed to archiveFilename.
+func (u *Updater) createArchive(fs FileSystem, archiveFilename string) (File, error) {
+	if err := fs.MkdirAll(u.ArchiveDir, u.dirMode()); err != nil {
+		return nil, fmt.Errorf("create archive directory: %w", err)
+	}
+	f, err := fs.OpenFile(archiveFilename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, u.fileMode())
 	if err != nil {
-		return false, fmt.Errorf("download md5 file: %w", err)
+		return nil, fmt.Errorf("create archive file: %w", err)
 	}
-	md5 = bytes.TrimSpace(md5)
+	return f, nil
+}
 
-	md5Filename := filepath.Join(filepath.Dir(filename), req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+// fresh reports whether the database saved under filename is modified
+// within the MinInterval, so that the update check is not needed.
+func (u *Updater) fresh(fs FileSystem, filename string) bool {
+	if u.MinInterval <= 0 || u.Force {
+		return false
+	}
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) < u.MinInterval
+}
 
-	if _, err := os.Stat(md5Filename); err == nil {
-		md5Current, err := ioutil.ReadFile(md5Filename)
-		if err != nil {
-			return false, fmt.Errorf("open md5 file: %w", err)
+// save writes the database with the download function to a temporary file
+// and renames it to filename, once the download is complete. The SHA256 sum
+// of the database is returned with the result. The result is not saved,
+// without an error, if the database is not accepted by the AcceptFunc. The
+// replace function, if it is not nil, is called after the database is
+// accepted and before it is replaced, and the database is not replaced if
+// it returns an error.
+func (u *Updater) save(fs FileSystem, filename string, download func(w io.Writer, preflight func(header http.Header, size int64) error) (*Metadata, int64, error), replace func() error) (result Result, dbSum []byte, err error) {
+	if err := checkDestination(fs, filename); err != nil {
+		return Result{}, nil, err
+	}
+	if err := fs.MkdirAll(filepath.Dir(filename), u.dirMode()); err != nil {
+		return Result{}, nil, fmt.Errorf("create directory: %w", err)
+	}
+
+	tmpFilename := filename + ".tmp"
+	f, err := fs.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, u.fileMode())
+	if err != nil {
+		return Result{}, nil, fmt.Errorf("create db file: %w", err)
+	}
+	// the temporary file is removed on any error, including the context
+	// cancellation during the download, leaving the existing database intact
+	defer func() {
+		if !result.Saved {
+			_ = fs.Remove(tmpFilename)
 		}
-		md5Current = bytes.TrimSpace(md5Current)
+	}()
 
-		if bytes.Equal(md5, md5Current) {
-			return false, nil
+	var w io.Writer = f
+	var gzw *gzip.Writer
+	if u.GzipOutput {
+		gzw = gzip.NewWriter(f)
+		w = gzw
+	}
+	dbHash := sha256.New()
+	if u.SHA256 {
+		w = io.MultiWriter(w, dbHash)
+	}
+	dw := &dbWriter{Writer: w}
+	if u.Preallocate && gzw == nil {
+		if t, ok := f.(truncater); ok {
+			dw.t = t
 		}
 	}
-	q.Set("suffix", "tar.gz")
-	u.RawQuery = q.Encode()
-	req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	if len(u.AuxiliaryFiles) > 0 {
+		dw.auxiliary = make(map[string][]byte)
+	}
+	w = dw
+
+	md, n, err := download(w, func(header http.Header, size int64) error {
+		result.Header = header
+		return u.checkDiskSpace(filepath.Dir(filename), size)
+	})
+	if gzw != nil {
+		if cerr := gzw.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close gzip writer: %w", cerr)
+		}
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("close db file: %w", cerr)
+	}
 	if err != nil {
-		return false, fmt.Errorf("http request: %w", err)
+		return Result{}, nil, err
 	}
-	if ctx != nil {
-		req = req.WithContext(ctx)
+
+	if u.AcceptFunc != nil {
+		// metadata is nil if the existing database can not be read
+		old, _ := u.readMetadata(fs, filename)
+		if !u.AcceptFunc(md, old) {
+			return Result{}, nil, nil
+		}
+	}
+
+	if _, err := fs.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		result.FirstDownload = true
+	}
+	if replace != nil {
+		if err := replace(); err != nil {
+			return Result{}, nil, err
+		}
+	}
+	if err := u.backup(fs, filename); err != nil {
+		return Result{}, nil, err
+	}
+
+	if err := fs.Rename(tmpFilename, filename); err != nil {
+		return Result{}, nil, fmt.Errorf("rename db file: %w", err)
+	}
+	result.Saved = true
+	for name, data := range dw.auxiliary {
+		if err := writeFile(fs, filepath.Join(filepath.Dir(filename), name), data, u.fileMode()); err != nil {
+			return result, nil, fmt.Errorf("write auxiliary file: %w", err)
+		}
+	}
+	result.Bytes = n
+	result.BuildEpoch = md.BuildEpoch
+
+	return result, dbHash.Sum(nil), nil
+}
+
+// preallocator is implemented by the database file writer that can be
+// preallocated to the size of the database.
+type preallocator interface {
+	preallocate(size int64) error
+}
+
+// auxiliaryCollector is implemented by the database file writer that saves
+// auxiliary files from the archive.
+type auxiliaryCollector interface {
+	addAuxiliary(name string, data []byte)
+}
+
+// truncater is implemented by files that can change their size.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// dbWriter writes to the database file Writer, preallocating the file with
+// the Truncate method, if it is set, and collecting auxiliary files, if the
+// map is not nil.
+type dbWriter struct {
+	io.Writer
+	t         truncater
+	auxiliary map[string][]byte
+}
+
+func (w *dbWriter) preallocate(size int64) error {
+	if w.t == nil {
+		return nil
+	}
+	return w.t.Truncate(size)
+}
+
+func (w *dbWriter) addAuxiliary(name string, data []byte) {
+	if w.auxiliary != nil {
+		w.auxiliary[name] = data
 	}
-	r, err = http.DefaultClient.Do(req)
+}
+
+// download downloads the tar archive of the edition and writes the content
+// of the database file from it to the writer. The whole archive is read and
+// its MD5 sum is compared with the expected checksum. Metadata and the size
+// of the database are returned, or ErrDatabaseNotInArchive if the database
+// file is not in the archive. If preflight is not nil, it is called with the
+// response header and the archive size before the download. If archive is
+// not nil, the received archive is written to it.
+func (u *Updater) download(ctx context.Context, w, archive io.Writer, editionID, dbname string, checksum []byte, preflight func(header http.Header, size int64) error) (md *Metadata, n int64, err error) {
+	var probe *http.Response
+	if u.Probe {
+		probe, err = u.probe(ctx, editionID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("probe tar: %w", err)
+		}
+		if probe != nil && preflight != nil {
+			if err := preflight(probe.Header, probe.ContentLength); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	r, err := u.get(ctx, editionID, u.suffix(), time.Time{})
 	if err != nil {
-		return false, fmt.Errorf("get tar: %w", err)
+		return nil, 0, fmt.Errorf("get tar: %w", err)
 	}
-	defer r.Body.Close()
-	if r.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected http response %s", r.Status)
+	if probe != nil && r.ContentLength < 0 && !r.Uncompressed {
+		if etag := probe.Header.Get("ETag"); etag != "" && etag == r.Header.Get("ETag") {
+			r.ContentLength = probe.ContentLength
+		}
 	}
+	if archive != nil {
+		r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, archive), Closer: r.Body}
+	}
+	return u.downloadFrom(r, w, editionID, u.suffix(), dbname, checksum, preflight)
+}
 
-	gzr, err := gzip.NewReader(r.Body)
+// errMethodNotAllowed is returned by getURL when the server does not
+// support HEAD requests.
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// probe requests the tar archive of the edition with the HEAD request. The
+// returned response has no body. If the server does not support HEAD
+// requests, nil response is returned without an error.
+func (u *Updater) probe(ctx context.Context, editionID string) (*http.Response, error) {
+	r, _, err := u.getRange(ctx, http.MethodHead, editionID, u.suffix(), time.Time{}, 0, u.Retries)
 	if err != nil {
-		return false, fmt.Errorf("gzip reader: %w", err)
+		if errors.Is(err, errMethodNotAllowed) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r.Body.Close()
+	return r, nil
+}
+
+// downloadFrom writes the content of the database file from the archive in
+// the response body with the suffix to the writer, in the same way as
+// download. If checksum is nil, the MD5 sum of the archive is not checked.
+// The response body is closed.
+func (u *Updater) downloadFrom(r *http.Response, w io.Writer, editionID, suffix, dbname string, checksum []byte, preflight func(header http.Header, size int64) error) (md *Metadata, n int64, err error) {
+	defer r.Body.Close()
+
+	if preflight != nil {
+		if err := preflight(r.Header, r.ContentLength); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	u.Hooks.downloadStart(editionID, r.ContentLength)
+
+	body, report := u.countBody(editionID, r.Body)
+	defer report()
+	if u.Progress != nil {
+		body = &progressReader{r: body, total: r.ContentLength, f: u.Progress}
+	}
+	c := &countReader{r: body}
+	md, n, err = u.extract(c, w, suffix, dbname, checksum)
+	if err == nil || errors.Is(err, ErrChecksumMismatch) {
+		if err := checkLength(r, c.n); err != nil {
+			return nil, 0, err
+		}
+	}
+	return md, n, err
+}
+
+// teeReadCloser is a response body that writes what is read from it to
+// another writer.
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// checkLength returns ErrIncompleteDownload if the number of bytes read from
+// the response body is not the same as its Content-Length, if it is known.
+func checkLength(r *http.Response, n int64) error {
+	if r.ContentLength >= 0 && n != r.ContentLength {
+		return fmt.Errorf("%w: received %v of %v bytes", ErrIncompleteDownload, n, r.ContentLength)
+	}
+	return nil
+}
+
+// extract writes the content of the database file from the archive with the
+// suffix read from the reader to the writer. The whole archive is read and
+// its MD5 sum is compared with the expected checksum, if it is not nil.
+// Metadata and the size of the database are returned, or
+// ErrDatabaseNotInArchive if the database file is not in the archive.
+func (u *Updater) extract(body io.Reader, w io.Writer, suffix, dbname string, checksum []byte) (md *Metadata, n int64, err error) {
+	h, algorithm := newChecksumHash(checksum)
+	body = io.TeeReader(body, h)
+	if u.BufferSize > 0 {
+		body = bufio.NewReaderSize(body, u.BufferSize)
+	}
+
+	var found bool
+	tail := &tailBuffer{size: metadataMaxSize}
+	switch suffix {
+	case tarGzSuffix:
+		gzr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, 0, fmt.Errorf("gzip reader: %w", err)
+		}
+		// archives may be recompressed with multiple gzip members
+		gzr.Multistream(true)
+		found, n, err = u.extractTar(gzr, w, tail, dbname)
+		if err != nil {
+			return nil, 0, err
+		}
+		// read the whole decompressed stream to verify gzip checksums of
+		// all members
+		if _, err := io.Copy(ioutil.Discard, gzr); err != nil {
+			return nil, 0, fmt.Errorf("read gzip: %w", err)
+		}
+	case tarSuffix:
+		found, n, err = u.extractTar(body, w, tail, dbname)
+		if err != nil {
+			return nil, 0, err
+		}
+	case mmdbSuffix:
+		n, err = u.copy(io.MultiWriter(w, tail), body)
+		if err != nil {
+			return nil, 0, fmt.Errorf("write db file: %w", err)
+		}
+		found = true
+	default:
+		return nil, 0, fmt.Errorf("unsupported suffix %q", suffix)
 	}
 
-	tr := tar.NewReader(gzr)
+	// read the rest of the archive to calculate its checksum
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, 0, fmt.Errorf("read archive: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); checksum != nil && got != string(checksum) {
+		return nil, 0, fmt.Errorf("archive %s %s, expected %s: %w", algorithm, got, checksum, ErrChecksumMismatch)
+	}
+
+	if !found {
+		return nil, 0, fmt.Errorf("%s: %w", dbname, ErrDatabaseNotInArchive)
+	}
+	md, err = parseMetadata(tail.b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	return md, n, nil
+}
 
+// extractTar writes the content of the database file from the tar archive
+// to the writer and the tail buffer, reporting whether the file is found.
+// The writer is preallocated to the size of the database file if it
+// implements preallocator. AuxiliaryFiles are passed to the writer if it
+// implements auxiliaryCollector.
+func (u *Updater) extractTar(r io.Reader, w, tail io.Writer, dbname string) (found bool, n int64, err error) {
+	collector, _ := w.(auxiliaryCollector)
+	if len(u.AuxiliaryFiles) == 0 {
+		collector = nil
+	}
+	tr := tar.NewReader(r)
 	for {
 		header, err := tr.Next()
 		if err != nil {
 			if err == io.EOF {
-				break
+				return found, n, nil
 			}
-			return false, fmt.Errorf("read tar: %w", err)
+			return false, 0, fmt.Errorf("read tar: %w", err)
 		}
-		if strings.HasSuffix(header.Name, "/"+dbname) {
-			if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
-				return false, fmt.Errorf("create directory: %w", err)
-			}
-			writer, err := os.Create(filename)
-			if err != nil {
-				return false, fmt.Errorf("create db file: %w", err)
+		// the database entry is never consumed as an auxiliary file, even
+		// if one of AuxiliaryFiles has the same name
+		if collector != nil && !matchEntry(header.Name, dbname) {
+			if err := u.collectAuxiliary(collector, tr, header); err != nil {
+				return false, 0, err
 			}
-			_, err = io.Copy(writer, tr)
-			_ = writer.Close()
-			if err != nil {
-				return false, fmt.Errorf("write db file: %w", err)
+		}
+		if found || !matchEntry(header.Name, dbname) {
+			continue
+		}
+		// only regular files are extracted, not links or other special
+		// files that may be in malformed archives
+		if header.Typeflag != tar.TypeReg {
+			return false, 0, fmt.Errorf("tar entry %s is not a regular file", header.Name)
+		}
+		if p, ok := w.(preallocator); ok {
+			if err := p.preallocate(header.Size); err != nil {
+				return false, 0, fmt.Errorf("preallocate db file: %w", err)
 			}
-			saved = true
+		}
+		n, err = u.copy(io.MultiWriter(w, tail), tr)
+		if err != nil {
+			return false, 0, fmt.Errorf("write db file: %w", err)
+		}
+		found = true
+		if collector == nil {
+			// the rest of the archive is not needed
+			return found, n, nil
+		}
+	}
+}
+
+// auxiliaryFileMaxSize is the maximal size of an auxiliary file from the
+// archive, as they are held in memory until the database is saved.
+const auxiliaryFileMaxSize = 1024 * 1024
+
+// collectAuxiliary passes the content of the tar entry to the collector if
+// it is a regular file with one of the AuxiliaryFiles names.
+func (u *Updater) collectAuxiliary(collector auxiliaryCollector, r io.Reader, header *tar.Header) error {
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	for _, name := range u.AuxiliaryFiles {
+		if !matchEntry(header.Name, name) {
+			continue
+		}
+		if header.Size > auxiliaryFileMaxSize {
+			return fmt.Errorf("auxiliary file %s is larger than %v bytes", header.Name, auxiliaryFileMaxSize)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read auxiliary file: %w", err)
+		}
+		collector.addAuxiliary(filepath.Base(name), data)
+		return nil
+	}
+	return nil
+}
+
+// matchEntry reports whether the tar archive entry name is the database
+// file with the provided name, in any directory. Both slash and backslash
+// are accepted as separators and the case is ignored.
+func matchEntry(name, dbname string) bool {
+	return strings.EqualFold(path.Base(strings.ReplaceAll(name, "\\", "/")), dbname)
+}
+
+// check downloads the MD5 sum of the archive with the suffix and compares it
+// with the one saved by the previous update.
+func (u *Updater) check(ctx context.Context, filename, editionID, suffix string) (checksum []byte, updateAvailable bool, err error) {
+	fs := u.fileSystem()
+
+	var state []byte
+	if !u.Force {
+		state, err = u.readState(fs, filename, editionID, suffix)
+		if err != nil {
+			return nil, false, fmt.Errorf("read state file: %w", err)
+		}
+	}
+	if state != nil && suffix != zipSuffix {
+		// the database is downloaded again if it is missing, even if the
+		// state file is present
+		if _, err := fs.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			state = nil
+		}
+	}
+
+	var modifiedSince time.Time
+	if u.IfModifiedSince && state != nil {
+		if info, err := fs.Stat(filename); err == nil {
+			modifiedSince = info.ModTime()
+		}
+	}
+
+	checksum, err = u.remoteChecksum(ctx, editionID, suffix, modifiedSince)
+	if err != nil {
+		if errors.Is(err, errNotModified) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if state != nil && bytes.Equal(u.stateKey(checksum), state) {
+		return checksum, false, nil
+	}
+	return checksum, true, nil
+}
+
+// remoteChecksum downloads the published checksum of the edition archive
+// with the suffix, the SHA256 sum with RemoteSHA256 option, if it is
+// published, or the MD5 sum. If modifiedSince is not zero, the request is
+// conditional and errNotModified is returned if the checksum file is not
+// modified since that time.
+func (u *Updater) remoteChecksum(ctx context.Context, editionID, suffix string, modifiedSince time.Time) (checksum []byte, err error) {
+	if u.RemoteSHA256 {
+		checksum, err := u.remoteSum(ctx, editionID, suffix, "sha256", modifiedSince)
+		if !errors.Is(err, ErrEditionNotFound) {
+			return checksum, err
+		}
+	}
+	return u.remoteMD5(ctx, editionID, suffix, modifiedSince)
+}
+
+// remoteMD5 downloads the published MD5 sum of the edition archive with the
+// suffix. If modifiedSince is not zero, the request is conditional and
+// errNotModified is returned if the MD5 sum file is not modified since that
+// time.
+func (u *Updater) remoteMD5(ctx context.Context, editionID, suffix string, modifiedSince time.Time) (checksum []byte, err error) {
+	return u.remoteSum(ctx, editionID, suffix, "md5", modifiedSince)
+}
+
+// remoteSum downloads the published checksum of the edition archive with
+// the suffix, calculated with the hash algorithm, md5 or sha256, which is
+// also the extension of the checksum file.
+func (u *Updater) remoteSum(ctx context.Context, editionID, suffix, algorithm string, modifiedSince time.Time) (checksum []byte, err error) {
+	r, err := u.get(ctx, editionID, suffix+"."+algorithm, modifiedSince)
+	if err != nil {
+		return nil, fmt.Errorf("get %s file: %w", algorithm, err)
+	}
+	defer r.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		return nil, fmt.Errorf("download %s file: %w", algorithm, err)
+	}
+	checksum = normalizeMD5(data)
+	if checksumAlgorithm(checksum) != algorithm {
+		data = bytes.TrimSpace(data)
+		if len(data) > 64 {
+			data = append(data[:64:64], "..."...)
+		}
+		return nil, fmt.Errorf("%w %q", ErrInvalidChecksum, data)
+	}
+	return checksum, nil
+}
+
+// normalizeMD5 returns the first whitespace separated field of the MD5 sum
+// file content in lower case, as mirrors may publish the sum in upper case
+// or followed by the file name, in the format of the md5sum tool.
+func normalizeMD5(data []byte) []byte {
+	fields := bytes.Fields(data)
+	if len(fields) == 0 {
+		return nil
+	}
+	return bytes.ToLower(fields[0])
+}
+
+// checksumAlgorithm returns the name of the hash algorithm of the hex
+// encoded checksum, md5 or sha256, or an empty string if the checksum is
+// not valid.
+func checksumAlgorithm(checksum []byte) string {
+	var algorithm string
+	switch len(checksum) {
+	case hex.EncodedLen(md5.Size):
+		algorithm = "md5"
+	case hex.EncodedLen(sha256.Size):
+		algorithm = "sha256"
+	default:
+		return ""
+	}
+	if _, err := hex.Decode(make([]byte, len(checksum)/2), checksum); err != nil {
+		return ""
+	}
+	return algorithm
+}
+
+// newChecksumHash returns the hash of the algorithm of the checksum, SHA256
+// for SHA256 sums and MD5 otherwise.
+func newChecksumHash(checksum []byte) (h hash.Hash, algorithm string) {
+	if len(checksum) == hex.EncodedLen(sha256.Size) {
+		return sha256.New(), "sha256"
+	}
+	return md5.New(), "md5"
+}
+
+// progressReader calls the progress function on every read.
+type progressReader struct {
+	r     io.Reader
+	n     int64
+	total int64
+	f     func(bytesDownloaded, totalBytes int64)
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.f(r.n, r.total)
+	}
+	return n, err
+}
+
+// errNotModified is returned by get on Not Modified response.
+var errNotModified = errors.New("not modified")
+
+// get requests a file with the provided suffix for the edition and returns
+// the response only if its status is OK. If modifiedSince is not zero, the
+// request has If-Modified-Since header and errNotModified is returned on Not
+// Modified response.
+func (u *Updater) get(ctx context.Context, editionID, suffix string, modifiedSince time.Time) (*http.Response, error) {
+	r, _, err := u.getRange(ctx, http.MethodGet, editionID, suffix, modifiedSince, 0, u.Retries)
+	return r, err
+}
+
+// getRange is the same as get, but with the provided HTTP method and, if the
+// offset is greater than zero, it requests the file content starting from
+// the offset and returns the response also if its status is Partial Content.
+// On Range Not Satisfiable response, errRangeNotSatisfiable is returned. The
+// request to every endpoint is retried up to the number of retries. Returned
+// temporary is true if the error is a network error or a server error
+// response, after which the request can be retried.
+func (u *Updater) getRange(ctx context.Context, method, editionID, suffix string, modifiedSince time.Time, offset int64, retries int) (r *http.Response, temporary bool, err error) {
+	baseURLs := u.BaseURLs
+	if len(baseURLs) == 0 {
+		baseURL := u.BaseURL
+		if baseURL == "" {
+			baseURL = DefaultBaseURL
+		}
+		baseURLs = []string{baseURL}
+	}
+	licenseKey, err := u.licenseKey()
+	if err != nil {
+		return nil, false, err
+	}
+	if licenseKey == "" && u.BaseURL == "" && len(u.BaseURLs) == 0 {
+		// custom endpoints, like mirrors, may not require the license key
+		return nil, false, ErrNoLicenseKey
+	}
+	for i, baseURL := range baseURLs {
+		r, temporary, err = u.getFrom(ctx, method, baseURL, licenseKey, editionID, suffix, modifiedSince, offset, retries)
+		if err == nil {
+			return r, false, nil
+		}
+		if !temporary || i == len(baseURLs)-1 || (ctx != nil && ctx.Err() != nil) {
 			break
 		}
 	}
+	return nil, temporary, err
+}
+
+// getFrom requests the file from the endpoint at the base URL, retrying the
+// request up to the number of retries. Returned fallback is true if the error
+// is a network error or a server error response, after which the request can
+// be made to another endpoint.
+func (u *Updater) getFrom(ctx context.Context, method, baseURL, licenseKey, editionID, suffix string, modifiedSince time.Time, offset int64, retries int) (r *http.Response, fallback bool, err error) {
+	addr, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse base url: %w", err)
+	}
+	q := addr.Query()
+	for k, v := range u.QueryParams {
+		q[k] = append([]string(nil), v...)
+	}
+	q.Set("edition_id", editionID)
+	if u.AccountID == "" {
+		q.Set("license_key", licenseKey)
+	} else {
+		// the license key is sent only with basic authentication
+		q.Del("license_key")
+	}
+	q.Set("suffix", suffix)
+	addr.RawQuery = q.Encode()
+
+	return u.getURL(ctx, method, addr.String(), licenseKey, editionID, modifiedSince, offset, retries)
+}
+
+// getURL requests the file from the URL, retrying the request up to the
+// number of retries, in the same way as getFrom. Credentials are sent only
+// with basic authentication, if the AccountID is set.
+func (u *Updater) getURL(ctx context.Context, method, addr, licenseKey, editionID string, modifiedSince time.Time, offset int64, retries int) (r *http.Response, fallback bool, err error) {
+	defer func() { err = redactError(err, licenseKey) }()
+
+	// the request always has a context, so that it is available to the
+	// transport and RequestFunc
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("http request: %w", err)
+	}
+	if u.AccountID != "" {
+		req.SetBasicAuth(u.AccountID, licenseKey)
+	}
+	userAgent := u.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if !modifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", modifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if u.RequestFunc != nil {
+		u.RequestFunc(req)
+	}
 
-	if saved {
-		if err := ioutil.WriteFile(md5Filename, md5, 0666); err != nil {
-			return false, fmt.Errorf("write md5 file: %w", err)
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+		if testTransport != nil {
+			client = &http.Client{Transport: testTransport}
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := u.wait(ctx, attempt); err != nil {
+				return nil, false, err
+			}
+		}
+		retry := attempt < retries
+		r, err := u.do(ctx, client, req)
+		if err != nil {
+			// the url of the request may contain the license key
+			var uerr *url.Error
+			if errors.As(err, &uerr) {
+				uerr.URL = RedactURL(uerr.URL)
+			}
+			err = redactError(err, licenseKey)
+			if retry && ctx.Err() == nil {
+				u.Hooks.retry(editionID, attempt+1, err)
+				continue
+			}
+			return nil, true, err
+		}
+		if r.StatusCode == http.StatusNotModified && !modifiedSince.IsZero() {
+			r.Body.Close()
+			return nil, false, errNotModified
 		}
-		if setTestM5Filename != nil {
-			setTestM5Filename(md5Filename)
+		if method == http.MethodHead && (r.StatusCode == http.StatusMethodNotAllowed || r.StatusCode == http.StatusNotImplemented) {
+			r.Body.Close()
+			return nil, false, errMethodNotAllowed
 		}
+		if r.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+			r.Body.Close()
+			return nil, false, errRangeNotSatisfiable
+		}
+		if r.StatusCode != http.StatusOK && !(r.StatusCode == http.StatusPartialContent && offset > 0) {
+			r.Body.Close()
+			err := fmt.Errorf("%s %s: %w", strings.ToLower(method), RedactURL(addr), statusError(r))
+			if r.StatusCode >= 500 {
+				if retry {
+					u.Hooks.retry(editionID, attempt+1, err)
+					continue
+				}
+				return nil, true, err
+			}
+			return nil, false, err
+		}
+		return r, false, nil
+	}
+}
+
+// copy copies from src to dst using the buffer of BufferSize, if it is set.
+func (u *Updater) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if u.BufferSize <= 0 {
+		return io.Copy(dst, src)
+	}
+	// hide io.ReaderFrom and io.WriterTo implementations, as io.CopyBuffer
+	// does not use the buffer if any of them is implemented
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, u.BufferSize))
+}
+
+// do sends the request with the client, limiting its duration with the
+// Timeout and the Deadline, if they are set.
+func (u *Updater) do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	deadline := u.Deadline
+	if u.Timeout > 0 {
+		if d := time.Now().Add(u.Timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if deadline.IsZero() {
+		return client.Do(req)
 	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	r, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.Body = &cancelReadCloser{ReadCloser: r.Body, cancel: cancel}
+	return r, nil
+}
+
+// cancelReadCloser cancels the request context when the response body is
+// closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
 
-	return saved, err
+// wait blocks for the exponential backoff duration before the retry attempt
+// or until the context is done.
+func (u *Updater) wait(ctx context.Context, attempt int) error {
+	backoff := u.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	d := backoff << uint(attempt-1)
+	if !u.Deadline.IsZero() && time.Now().Add(d).After(u.Deadline) {
+		// the retry would not be sent before the deadline
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
 }
 
 var (
 	testMD5Filename   string
 	setTestM5Filename func(md5Filename string)
+	// testTransport is used for HTTP requests when Client is not set, so
+	// that package functions can be tested without network access.
+	testTransport http.RoundTripper
 )
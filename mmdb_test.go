@@ -5,20 +5,46 @@
 package mmdb
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
 )
 
 var licenseKey = os.Getenv("GO_TEST_MMDB_LICENSE_KEY")
 
+var testBuildEpoch = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// testMD5FilenameMu guards testMD5Filename that is set by concurrent
+// updates.
+var testMD5FilenameMu sync.Mutex
+
 func init() {
 	setTestM5Filename = func(md5Filename string) {
+		testMD5FilenameMu.Lock()
 		testMD5Filename = md5Filename
+		testMD5FilenameMu.Unlock()
 	}
 }
 
@@ -35,6 +61,13 @@ func TestUpdateGeoLite2ASN(t *testing.T) {
 }
 
 func testUpdate(t *testing.T, f func(ctx context.Context, filename, licenseKey string) (saved bool, err error)) {
+	licenseKey := licenseKey
+	if licenseKey == "" {
+		// without the license key, serve databases from the test server
+		defer setTestServerTransport(t)()
+		licenseKey = "test"
+	}
+
 	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
 	if err != nil {
 		t.Fatal(err)
@@ -129,6 +162,180 @@ func testUpdate(t *testing.T, f func(ctx context.Context, filename, licenseKey s
 	}
 }
 
+func TestUpdateGeoLite2CountryToDir(t *testing.T) {
+	testUpdateToDir(t, UpdateGeoLite2CountryToDir, "GeoLite2-Country")
+}
+
+func TestUpdateGeoLite2CityToDir(t *testing.T) {
+	testUpdateToDir(t, UpdateGeoLite2CityToDir, "GeoLite2-City")
+}
+
+func TestUpdateGeoLite2ASNToDir(t *testing.T) {
+	testUpdateToDir(t, UpdateGeoLite2ASNToDir, "GeoLite2-ASN")
+}
+
+func testUpdateToDir(t *testing.T, f func(ctx context.Context, dir, licenseKey string) (saved bool, err error), editionID string) {
+	defer setTestServerTransport(t)()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	saved, err := f(context.Background(), dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, editionID+".mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mmdbtest.Database(editionID, testBuildEpoch); !bytes.Equal(got, want) {
+		t.Errorf("got database %q, want %q", got, want)
+	}
+
+	saved, err = f(context.Background(), dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+}
+
+// setTestServerTransport starts the test server with all GeoLite2 databases
+// and routes requests made without the Client option to it. The returned
+// function stops the server and resets the transport.
+func setTestServerTransport(t *testing.T) (reset func()) {
+	t.Helper()
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-Country": mmdbtest.Database("GeoLite2-Country", testBuildEpoch),
+		"GeoLite2-City":    mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+		"GeoLite2-ASN":     mmdbtest.Database("GeoLite2-ASN", testBuildEpoch),
+	})
+	resetTransport := setTestTransport(t, ts.URL)
+	return func() {
+		resetTransport()
+		ts.Close()
+	}
+}
+
+// setTestTransport routes requests made without the Client option to the
+// server with the URL. The returned function resets the transport.
+func setTestTransport(t *testing.T, serverURL string) (reset func()) {
+	t.Helper()
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme = u.Scheme
+		r.URL.Host = u.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	return func() {
+		testTransport = nil
+	}
+}
+
+func TestUpdateGeoLite2City_canceled(t *testing.T) {
+	defer setTestServerTransport(t)()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	filename := filepath.Join(dir, "GeoLite2-City.mmdb")
+	saved, err := UpdateGeoLite2City(ctx, filename, "test")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if saved {
+		t.Error("got saved")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("got stat error %v, want not exist", err)
+	}
+}
+
+func TestUpdateEditionTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// stall until the request is canceled
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer ts.Close()
+	defer close(stop)
+	defer setTestTransport(t, ts.URL)()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	timeout := 50 * time.Millisecond
+	for _, tc := range []struct {
+		name   string
+		update func(filename string) (bool, error)
+	}{
+		{
+			name: "edition",
+			update: func(filename string) (bool, error) {
+				return UpdateEditionTimeout(filename, "GeoLite2-City", "test", timeout)
+			},
+		},
+		{
+			name: "country",
+			update: func(filename string) (bool, error) {
+				return UpdateGeoLite2CountryTimeout(filename, "test", timeout)
+			},
+		},
+		{
+			name: "city",
+			update: func(filename string) (bool, error) {
+				return UpdateGeoLite2CityTimeout(filename, "test", timeout)
+			},
+		},
+		{
+			name: "asn",
+			update: func(filename string) (bool, error) {
+				return UpdateGeoLite2ASNTimeout(filename, "test", timeout)
+			},
+		},
+	} {
+		filename := filepath.Join(dir, tc.name+".mmdb")
+		start := time.Now()
+		saved, err := tc.update(filename)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, context.DeadlineExceeded)
+		}
+		if d := time.Since(start); d > 10*timeout {
+			t.Errorf("%s: got duration %v, want about %v", tc.name, d, timeout)
+		}
+		if saved {
+			t.Errorf("%s: got saved", tc.name)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s: got stat error %v, want not exist", tc.name, err)
+		}
+	}
+}
+
 func fileMD5(t *testing.T, filename string) (hash string) {
 	t.Helper()
 
@@ -145,3 +352,2189 @@ func fileMD5(t *testing.T, filename string) (hash string) {
 	}
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
+
+func TestUpdater_BaseURL(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{
+		LicenseKey: "test-key",
+		BaseURL:    ts.URL + "/app/geoip_download",
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	requests := ts.Requests()
+	wantSuffixes := []string{"tar.gz.md5", "tar.gz", "tar.gz.md5"}
+	if len(requests) != len(wantSuffixes) {
+		t.Fatalf("got %v requests, want %v", len(requests), len(wantSuffixes))
+	}
+	for i, r := range requests {
+		if r.Path != "/app/geoip_download" {
+			t.Errorf("request %v: got path %q, want %q", i, r.Path, "/app/geoip_download")
+		}
+		q := r.Query()
+		if got := q.Get("edition_id"); got != "GeoLite2-City" {
+			t.Errorf("request %v: got edition_id %q, want %q", i, got, "GeoLite2-City")
+		}
+		if got := q.Get("license_key"); got != "test-key" {
+			t.Errorf("request %v: got license_key %q, want %q", i, got, "test-key")
+		}
+		if got := q.Get("suffix"); got != wantSuffixes[i] {
+			t.Errorf("request %v: got suffix %q, want %q", i, got, wantSuffixes[i])
+		}
+	}
+}
+
+func TestUpdater_BaseURLs(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	result, err := (&Updater{
+		BaseURLs: []string{unavailable.URL, closed.URL, ts.URL},
+	}).City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("got invalid database")
+	}
+
+	_, err = (&Updater{
+		BaseURLs: []string{notFound.URL, ts.URL},
+		Force:    true,
+	}).City(context.Background(), filename)
+	if !errors.Is(err, ErrEditionNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrEditionNotFound)
+	}
+}
+
+func TestUpdater_CheckCity(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{
+		LicenseKey: "test-key",
+		BaseURL:    ts.URL,
+	}
+
+	check := func(want bool) {
+		t.Helper()
+
+		updateAvailable, err := u.CheckCity(context.Background(), filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updateAvailable != want {
+			t.Errorf("got update available %v, want %v", updateAvailable, want)
+		}
+	}
+
+	check(true)
+
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	check(false)
+
+	ts.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", testBuildEpoch.AddDate(0, 0, 7)))
+
+	check(true)
+
+	var tarRequests int
+	for _, r := range ts.Requests() {
+		if r.Query().Get("suffix") == "tar.gz" {
+			tarRequests++
+		}
+	}
+	if tarRequests != 1 {
+		t.Errorf("got %v tar requests, want 1", tarRequests)
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+}
+
+func TestUpdater_contextCancelled(t *testing.T) {
+	db := bytes.Repeat([]byte("city database "), 100000)
+	archive := testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", db)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz.md5" {
+			fmt.Fprintf(w, "%x", md5.Sum(archive))
+			return
+		}
+		// send only a part of the archive and stall
+		_, _ = w.Write(archive[:len(archive)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	if err := ioutil.WriteFile(filename, []byte("old city database"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	result, err := (&Updater{BaseURL: ts.URL}).City(ctx, filename)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "old city database" {
+		t.Errorf("got database %q, want %q", got, "old city database")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		for _, f := range files {
+			t.Log(f.Name())
+		}
+		t.Errorf("got %v files, want 1", len(files))
+	}
+}
+
+func TestUpdater_Retries(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		status       int
+		retries      int
+		wantRequests int
+		wantErr      bool
+	}{
+		{
+			name:         "server error",
+			status:       http.StatusServiceUnavailable,
+			retries:      2,
+			wantRequests: 4,
+		},
+		{
+			name:         "too many server errors",
+			status:       http.StatusServiceUnavailable,
+			retries:      1,
+			wantRequests: 2,
+			wantErr:      true,
+		},
+		{
+			name:         "unauthorized",
+			status:       http.StatusUnauthorized,
+			retries:      2,
+			wantRequests: 1,
+			wantErr:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := mmdbtest.NewServer(map[string][]byte{
+				"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+			})
+			defer ts.Close()
+
+			var mu sync.Mutex
+			var requests int
+			errorsLeft := 2
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				fail := errorsLeft > 0
+				if fail {
+					errorsLeft--
+				}
+				mu.Unlock()
+				if fail {
+					w.WriteHeader(tc.status)
+					return
+				}
+				ts.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer s.Close()
+
+			dir, err := ioutil.TempDir("", "mmdb_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			u := &Updater{
+				BaseURL:      s.URL,
+				Retries:      tc.retries,
+				RetryBackoff: time.Millisecond,
+			}
+			result, err := u.City(context.Background(), filepath.Join(dir, "city.mmdb"))
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected error, got none")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Saved {
+					t.Error("expected file to be saved, but it is not")
+				}
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("got %v requests, want %v", requests, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestUpdater_checksumMismatch(t *testing.T) {
+	archive := testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", mmdbtest.Database("GeoLite2-City", testBuildEpoch))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz.md5" {
+			fmt.Fprintf(w, "%x", md5.Sum([]byte("other archive")))
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	result, err := (&Updater{BaseURL: ts.URL}).City(context.Background(), filename)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got error %v, want %v", err, ErrChecksumMismatch)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %v files, want none", len(files))
+	}
+}
+
+func TestUpdater_checksumMismatch_staleMD5(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var md5Requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz.md5" {
+			mu.Lock()
+			md5Requests++
+			stale := md5Requests == 1
+			mu.Unlock()
+			if stale {
+				fmt.Fprintf(w, "%x", md5.Sum([]byte("previous archive")))
+				return
+			}
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	result, err := (&Updater{BaseURL: s.URL, FileSystem: fs}).City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if want := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City"))); result.MD5 != want {
+		t.Errorf("got md5 %q, want %q", result.MD5, want)
+	}
+	if !bytes.Equal(fs.file(filename), db) {
+		t.Error("got invalid database")
+	}
+	if md5Requests != 2 {
+		t.Errorf("got %v md5 requests, want %v", md5Requests, 2)
+	}
+}
+
+func TestUpdater_Progress(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": append(bytes.Repeat([]byte("city database "), 100000), mmdbtest.Database("GeoLite2-City", testBuildEpoch)...),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var calls int
+	var downloaded, total int64
+	u := &Updater{
+		BaseURL: ts.URL,
+		Progress: func(bytesDownloaded, totalBytes int64) {
+			calls++
+			if bytesDownloaded < downloaded {
+				t.Errorf("downloaded bytes decreased from %v to %v", downloaded, bytesDownloaded)
+			}
+			downloaded = bytesDownloaded
+			total = totalBytes
+		},
+	}
+	if _, err := u.City(context.Background(), filepath.Join(dir, "city.mmdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveSize := int64(len(ts.Archive("GeoLite2-City")))
+	if calls == 0 {
+		t.Error("progress function not called")
+	}
+	if downloaded != archiveSize {
+		t.Errorf("got %v downloaded bytes, want %v", downloaded, archiveSize)
+	}
+	if total != archiveSize {
+		t.Errorf("got %v total bytes, want %v", total, archiveSize)
+	}
+}
+
+func TestUpdater_Update(t *testing.T) {
+	db := mmdbtest.Database("GeoIP2-ISP", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoIP2-ISP": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "isp.mmdb")
+
+	result, err := (&Updater{BaseURL: ts.URL}).Update(context.Background(), filename, "GeoIP2-ISP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+}
+
+func TestUpdater_DatabaseFilename(t *testing.T) {
+	db := mmdbtest.Database("Custom", testBuildEpoch)
+	asnDB := mmdbtest.Database("GeoLite2-ASN", testBuildEpoch)
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-ASN": asnDB,
+	})
+	defer ts.Close()
+	ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, "custom/custom.mmdb", db))
+
+	fs := newMemFileSystem()
+	dir := filepath.FromSlash("/data")
+
+	u := &Updater{
+		BaseURL: ts.URL,
+		DatabaseFilename: map[string]string{
+			"GeoLite2-City": "custom.mmdb",
+		},
+		FileSystem: fs,
+	}
+	results, err := u.UpdateEditions(context.Background(), dir, "GeoLite2-City", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for editionID, want := range map[string][]byte{
+		"GeoLite2-City": db,
+		"GeoLite2-ASN":  asnDB,
+	} {
+		if !results[editionID].Saved {
+			t.Errorf("%s: got not saved", editionID)
+		}
+		if got := fs.file(filepath.Join(dir, editionID+".mmdb")); !bytes.Equal(got, want) {
+			t.Errorf("%s: got database %q, want %q", editionID, got, want)
+		}
+	}
+}
+
+func TestUpdater_Download(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	if err := (&Updater{BaseURL: ts.URL}).Download(context.Background(), &buf, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), db) {
+		t.Errorf("got database %q, want %q", buf.Bytes(), db)
+	}
+}
+
+func TestUpdater_DownloadBytes(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	got, md5sum, err := (&Updater{BaseURL: ts.URL}).DownloadBytes(context.Background(), "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+	if want := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City"))); md5sum != want {
+		t.Errorf("got md5 %q, want %q", md5sum, want)
+	}
+}
+
+func TestUpdater_UpdateFile(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString("previous content that is longer than the database"); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{BaseURL: ts.URL}
+	result, err := u.UpdateFile(context.Background(), f, "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	u.PreviousMD5 = map[string]string{"GeoLite2-City": result.MD5}
+	result, err = u.UpdateFile(context.Background(), f, "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if !result.FromCache {
+		t.Error("got not from cache")
+	}
+}
+
+func TestUpdater_QueryParams(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	u := &Updater{
+		BaseURL:    ts.URL + "?mirror=eu",
+		LicenseKey: "license",
+		QueryParams: url.Values{
+			"token":      []string{"secret"},
+			"edition_id": []string{"GeoLite2-ASN"},
+		},
+		FileSystem: newMemFileSystem(),
+	}
+	if _, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := ts.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %v requests, want %v", len(requests), 2)
+	}
+	for _, r := range requests {
+		q := r.Query()
+		for k, want := range map[string]string{
+			"mirror":      "eu",
+			"token":       "secret",
+			"edition_id":  "GeoLite2-City",
+			"license_key": "license",
+		} {
+			if got := q.Get(k); got != want {
+				t.Errorf("got query parameter %s %q, want %q", k, got, want)
+			}
+		}
+	}
+}
+
+func TestUpdater_QueryParams_accountID(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	u := &Updater{
+		BaseURL:    ts.URL + "?license_key=base",
+		AccountID:  "123456",
+		LicenseKey: "license",
+		QueryParams: url.Values{
+			"token":       []string{"secret"},
+			"license_key": []string{"param"},
+		},
+		FileSystem: newMemFileSystem(),
+	}
+	if _, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := ts.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %v requests, want %v", len(requests), 2)
+	}
+	for _, r := range requests {
+		q := r.Query()
+		if _, ok := q["license_key"]; ok {
+			t.Errorf("got license_key query parameter %q", q["license_key"])
+		}
+		if got, want := q.Get("token"), "secret"; got != want {
+			t.Errorf("got query parameter token %q, want %q", got, want)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// the release tag is the version with the v prefix
+	if !regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`).MatchString(Version) {
+		t.Errorf("got version %q, want semantic version without the v prefix", Version)
+	}
+}
+
+func TestUpdater_UserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name: "default",
+			want: "janos-mmdb/" + Version,
+		},
+		{
+			name:      "custom",
+			userAgent: "custom-agent/1.0",
+			want:      "custom-agent/1.0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := mmdbtest.NewServer(map[string][]byte{
+				"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+			})
+			defer ts.Close()
+
+			var mu sync.Mutex
+			var userAgents []string
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				userAgents = append(userAgents, r.UserAgent())
+				mu.Unlock()
+				ts.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer s.Close()
+
+			dir, err := ioutil.TempDir("", "mmdb_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			u := &Updater{
+				BaseURL:   s.URL,
+				UserAgent: tc.userAgent,
+			}
+			if _, err := u.City(context.Background(), filepath.Join(dir, "city.mmdb")); err != nil {
+				t.Fatal(err)
+			}
+			if len(userAgents) != 2 {
+				t.Fatalf("got %v requests, want 2", len(userAgents))
+			}
+			for _, got := range userAgents {
+				if got != tc.want {
+					t.Errorf("got user agent %q, want %q", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdater_RequestFunc(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		fmt.Fprintln(w, "d41d8cd98f00b204e9800998ecf8427e")
+	}))
+	defer ts.Close()
+
+	if _, err := (&Updater{
+		BaseURL: ts.URL,
+		RequestFunc: func(r *http.Request) {
+			r.Header.Set("X-Request-ID", "test-id")
+		},
+	}).RemoteMD5(context.Background(), "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"test-id"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got request ids %q, want %q", ids, want)
+	}
+}
+
+func TestUpdater_requestContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "d41d8cd98f00b204e9800998ecf8427e")
+	}))
+	defer ts.Close()
+
+	type contextKey struct{}
+
+	var values []interface{}
+	u := &Updater{
+		BaseURL: ts.URL,
+		Client: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				values = append(values, r.Context().Value(contextKey{}))
+				return http.DefaultTransport.RoundTrip(r)
+			}),
+		},
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, "trace")
+	if _, err := u.RemoteMD5(ctx, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	// requests without the context have the background one
+	if _, err := u.RemoteMD5(nil, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"trace", nil}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got context values %v, want %v", values, want)
+	}
+}
+
+func TestUpdater_IfModifiedSince(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var modifiedSince []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		modifiedSince = append(modifiedSince, r.Header.Get("If-Modified-Since"))
+		mu.Unlock()
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{
+		BaseURL:         s.URL,
+		IfModifiedSince: true,
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	want := []string{"", "", info.ModTime().UTC().Format(http.TimeFormat)}
+	if len(modifiedSince) != len(want) {
+		t.Fatalf("got %v requests, want %v", len(modifiedSince), len(want))
+	}
+	for i := range want {
+		if modifiedSince[i] != want[i] {
+			t.Errorf("request %v: got If-Modified-Since %q, want %q", i, modifiedSince[i], want[i])
+		}
+	}
+}
+
+func TestUpdater_FileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported on windows")
+	}
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "data", "city.mmdb")
+
+	u := &Updater{
+		BaseURL:  ts.URL,
+		FileMode: 0640,
+		DirMode:  0750,
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		filename string
+		want     os.FileMode
+	}{
+		{filename: filepath.Dir(filename), want: 0750},
+		{filename: filename, want: 0640},
+		{filename: filepath.Join(filepath.Dir(filename), "GeoLite2-City.tar.gz.md5"), want: 0640},
+	} {
+		info, err := os.Stat(tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tc.want {
+			t.Errorf("%s: got mode %v, want %v", tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestUpdater_StateDir(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataDir := filepath.Join(dir, "data")
+	stateDir := filepath.Join(dir, "state")
+	filename := filepath.Join(dataDir, "city.mmdb")
+
+	u := &Updater{
+		BaseURL:  ts.URL,
+		StateDir: stateDir,
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(stateDir, "GeoLite2-City.tar.gz.md5")); err != nil {
+		t.Error(err)
+	}
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %v files in data directory, want 1", len(files))
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+}
+
+func TestUpdater_Force(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	if _, err := (&Updater{BaseURL: ts.URL}).City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the database
+	if err := ioutil.WriteFile(filename, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := (&Updater{BaseURL: ts.URL, Force: true}).City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+}
+
+func TestUpdater_invalidDatabase(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": []byte("<html>error</html>"),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	if err := ioutil.WriteFile(filename, db, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := (&Updater{BaseURL: ts.URL}).City(context.Background(), filename)
+	if !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidDatabase)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %v files, want 1", len(files))
+	}
+}
+
+func TestUpdater_Result(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{BaseURL: ts.URL}
+	archiveMD5 := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := result.Header.Get("Content-Length"), strconv.Itoa(len(ts.Archive("GeoLite2-City"))); got != want {
+		t.Errorf("got content length header %q, want %q", got, want)
+	}
+	result.Header = nil
+	want := Result{
+		Edition:       "GeoLite2-City",
+		Saved:         true,
+		FirstDownload: true,
+		Bytes:         int64(len(db)),
+		MD5:           archiveMD5,
+		BuildEpoch:    testBuildEpoch,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got result %+v, want %+v", result, want)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Result{
+		Edition:    "GeoLite2-City",
+		FromCache:  true,
+		MD5:        archiveMD5,
+		BuildEpoch: testBuildEpoch,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got result %+v, want %+v", result, want)
+	}
+
+	u.Force = true
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if result.FirstDownload {
+		t.Error("got first download")
+	}
+}
+
+func TestUpdater_Timeout(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	stall := make(chan struct{})
+	defer close(stall)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &Updater{
+		BaseURL: s.URL,
+		Timeout: 100 * time.Millisecond,
+	}
+	_, err = u.City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUpdater_Deadline(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	stall := make(chan struct{})
+	defer close(stall)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	u := &Updater{
+		BaseURL:      s.URL,
+		Deadline:     start.Add(100 * time.Millisecond),
+		Retries:      5,
+		RetryBackoff: time.Second,
+	}
+	_, err = u.City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Errorf("got duration %v after the deadline", d)
+	}
+}
+
+func TestUpdater_RemoteMD5(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	got, err := (&Updater{BaseURL: ts.URL}).RemoteMD5(context.Background(), "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+	if got != want {
+		t.Errorf("got md5 %q, want %q", got, want)
+	}
+}
+
+func TestUpdater_invalidMD5(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html><body>Service temporarily unavailable</body></html>")
+	}))
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	_, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidChecksum)
+	}
+	if got := fs.filenames(); len(got) != 0 {
+		t.Errorf("got files %q", got)
+	}
+}
+
+func TestUpdater_md5Format(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	archiveMD5 := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz.md5" {
+			fmt.Fprintf(w, "%s  GeoLite2-City.tar.gz\n", strings.ToUpper(archiveMD5))
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	fs := newMemFileSystem()
+	u := &Updater{BaseURL: s.URL, FileSystem: fs}
+
+	result, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if result.MD5 != archiveMD5 {
+		t.Errorf("got md5 %q, want %q", result.MD5, archiveMD5)
+	}
+	if got := string(fs.file(filepath.FromSlash("/data/GeoLite2-City.tar.gz.md5"))); got != archiveMD5 {
+		t.Errorf("got state %q, want %q", got, archiveMD5)
+	}
+
+	result, err = u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+}
+
+func TestUpdater_RemoteSHA256(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	archiveSHA256 := fmt.Sprintf("%x", sha256.Sum256(ts.Archive("GeoLite2-City")))
+	archiveMD5 := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+
+	for _, tc := range []struct {
+		name         string
+		sha256       string
+		want         string
+		wantSuffixes []string
+		wantErr      error
+	}{
+		{
+			name:         "published",
+			sha256:       archiveSHA256,
+			want:         archiveSHA256,
+			wantSuffixes: []string{"tar.gz.sha256", "tar.gz"},
+		},
+		{
+			name:         "not published",
+			want:         archiveMD5,
+			wantSuffixes: []string{"tar.gz.sha256", "tar.gz.md5", "tar.gz"},
+		},
+		{
+			name:    "mismatch",
+			sha256:  fmt.Sprintf("%x", sha256.Sum256([]byte("other archive"))),
+			wantErr: ErrChecksumMismatch,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var suffixes []string
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				suffix := r.URL.Query().Get("suffix")
+				mu.Lock()
+				suffixes = append(suffixes, suffix)
+				mu.Unlock()
+				if suffix == "tar.gz.sha256" && tc.sha256 != "" {
+					fmt.Fprintf(w, "%s  GeoLite2-City.tar.gz\n", tc.sha256)
+					return
+				}
+				ts.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer s.Close()
+
+			fs := newMemFileSystem()
+			u := &Updater{BaseURL: s.URL, FileSystem: fs, RemoteSHA256: true}
+
+			result, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			if result.MD5 != tc.want {
+				t.Errorf("got checksum %q, want %q", result.MD5, tc.want)
+			}
+			if !reflect.DeepEqual(suffixes, tc.wantSuffixes) {
+				t.Errorf("got suffixes %q, want %q", suffixes, tc.wantSuffixes)
+			}
+		})
+	}
+}
+
+func TestUpdater_RemoteBuildDate(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	// the build date is in the name of the archive directory
+	ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", mmdbtest.Database("GeoLite2-City", testBuildEpoch)))
+
+	date, err := (&Updater{BaseURL: ts.URL}).RemoteBuildDate(context.Background(), "GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !date.Equal(testBuildEpoch) {
+		t.Errorf("got build date %v, want %v", date, testBuildEpoch)
+	}
+
+	ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, "GeoLite2-City.mmdb", nil))
+	_, err = (&Updater{BaseURL: ts.URL}).RemoteBuildDate(context.Background(), "GeoLite2-City")
+	if !errors.Is(err, ErrBuildDateNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrBuildDateNotFound)
+	}
+}
+
+func TestUpdater_BufferSize(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	for _, size := range []int{1, 1 << 20} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mmdb_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "city.mmdb")
+
+			result, err := (&Updater{BaseURL: ts.URL, BufferSize: size}).City(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			got, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, db) {
+				t.Error("got invalid database")
+			}
+		})
+	}
+}
+
+func TestUpdater_GzipOutput(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb.gz")
+	u := &Updater{
+		BaseURL:    ts.URL,
+		GzipOutput: true,
+		FileSystem: fs,
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(fs.file(filename)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("got invalid database")
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if !result.BuildEpoch.Equal(testBuildEpoch) {
+		t.Errorf("got build epoch %v, want %v", result.BuildEpoch, testBuildEpoch)
+	}
+}
+
+func TestUpdater_ArchiveDir(t *testing.T) {
+	for _, resume := range []bool{false, true} {
+		t.Run(fmt.Sprintf("resume %v", resume), func(t *testing.T) {
+			ts := mmdbtest.NewServer(map[string][]byte{
+				"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+			})
+			defer ts.Close()
+
+			fs := newMemFileSystem()
+			archiveFilename := filepath.FromSlash("/archives/GeoLite2-City.tar.gz")
+			u := &Updater{
+				BaseURL:    ts.URL,
+				ArchiveDir: filepath.FromSlash("/archives"),
+				Resume:     resume,
+				FileSystem: fs,
+			}
+
+			result, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			archive := ts.Archive("GeoLite2-City")
+			if got := fs.file(archiveFilename); !bytes.Equal(got, archive) {
+				t.Errorf("got archive %q, want %q", got, archive)
+			}
+			if got, want := fmt.Sprintf("%x", md5.Sum(fs.file(archiveFilename))), result.MD5; got != want {
+				t.Errorf("got archive md5 %s, want %s", got, want)
+			}
+
+			// the archive is not replaced by a failed update
+			ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, "GeoLite2-City_20200103/LICENSE.txt", []byte("license")))
+			u.Force = true
+			if _, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb")); !errors.Is(err, ErrDatabaseNotInArchive) {
+				t.Errorf("got error %v, want %v", err, ErrDatabaseNotInArchive)
+			}
+			if got := fs.file(archiveFilename); !bytes.Equal(got, archive) {
+				t.Errorf("got archive %q, want %q", got, archive)
+			}
+			if got := fs.file(archiveFilename + ".tmp"); got != nil {
+				t.Errorf("got temporary archive %q", got)
+			}
+		})
+	}
+}
+
+func TestUpdater_ArchiveDir_editions(t *testing.T) {
+	editionIDs := []string{"GeoLite2-ASN", "GeoLite2-City", "GeoLite2-Country"}
+	databases := make(map[string][]byte)
+	for _, editionID := range editionIDs {
+		databases[editionID] = mmdbtest.Database(editionID, testBuildEpoch)
+	}
+	ts := mmdbtest.NewServer(databases)
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	u := &Updater{
+		BaseURL:     ts.URL,
+		ArchiveDir:  filepath.FromSlash("/archives"),
+		Concurrency: len(editionIDs),
+		FileSystem:  fs,
+	}
+	results, err := u.UpdateEditions(context.Background(), filepath.FromSlash("/data"), editionIDs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, editionID := range editionIDs {
+		if !results[editionID].Saved {
+			t.Errorf("%s: got not saved", editionID)
+		}
+		archive := ts.Archive(editionID)
+		if got := fs.file(filepath.FromSlash("/archives/" + editionID + ".tar.gz")); !bytes.Equal(got, archive) {
+			t.Errorf("%s: got archive %q, want %q", editionID, got, archive)
+		}
+		if got := fs.file(MD5Filename(filepath.FromSlash("/data"), editionID)); got == nil {
+			t.Errorf("%s: got no state file", editionID)
+		}
+	}
+}
+
+func TestUpdater_ArchiveDir_renameError(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	errRename := errors.New("rename error")
+	fs := &renameErrorFileSystem{
+		memFileSystem: newMemFileSystem(),
+		name:          filepath.FromSlash("/archives/GeoLite2-City.tar.gz"),
+		err:           errRename,
+	}
+	filename := filepath.FromSlash("/data/city.mmdb")
+	_, err := (&Updater{
+		BaseURL:    ts.URL,
+		ArchiveDir: filepath.FromSlash("/archives"),
+		FileSystem: fs,
+	}).City(context.Background(), filename)
+	if !errors.Is(err, errRename) {
+		t.Fatalf("got error %v, want %v", err, errRename)
+	}
+	// the database is not replaced without the archive
+	if got := fs.filenames(); len(got) != 0 {
+		t.Errorf("got files %q, want none", got)
+	}
+}
+
+// renameErrorFileSystem returns the error when a file is renamed to the
+// name.
+type renameErrorFileSystem struct {
+	*memFileSystem
+	name string
+	err  error
+}
+
+func (fs *renameErrorFileSystem) Rename(oldpath, newpath string) error {
+	if newpath == fs.name {
+		return fs.err
+	}
+	return fs.memFileSystem.Rename(oldpath, newpath)
+}
+
+func TestUpdater_Probe(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		headStatus int
+		// the size is known only from a successful probe
+		wantSize bool
+	}{
+		{name: "head", wantSize: true},
+		{name: "method not allowed", headStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := mmdbtest.NewServer(map[string][]byte{
+				"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+			})
+			defer ts.Close()
+			archive := ts.Archive("GeoLite2-City")
+
+			var mu sync.Mutex
+			var headCount int
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("suffix") != "tar.gz" {
+					ts.Config.Handler.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				if r.Method == http.MethodHead {
+					mu.Lock()
+					headCount++
+					mu.Unlock()
+					if tc.headStatus != 0 {
+						w.WriteHeader(tc.headStatus)
+						return
+					}
+					ts.Config.Handler.ServeHTTP(w, r)
+					return
+				}
+				// response without Content-Length
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				_, _ = w.Write(archive)
+			}))
+			defer s.Close()
+
+			var total int64
+			u := &Updater{
+				BaseURL:    s.URL,
+				Probe:      true,
+				FileSystem: newMemFileSystem(),
+				Progress: func(bytesDownloaded, totalBytes int64) {
+					total = totalBytes
+				},
+			}
+			result, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			want := int64(-1)
+			if tc.wantSize {
+				want = int64(len(archive))
+			}
+			if total != want {
+				t.Errorf("got total %v, want %v", total, want)
+			}
+			if headCount != 1 {
+				t.Errorf("got %v head requests, want 1", headCount)
+			}
+		})
+	}
+}
+
+func TestUpdater_Preallocate(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{
+		BaseURL:     ts.URL,
+		Preallocate: true,
+		SHA256:      true,
+	}
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(testArchive(t, "GeoLite2-City.mmdb", db)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &testPreallocWriter{}
+	if _, _, err := u.extractTar(gzr, w, ioutil.Discard, "GeoLite2-City.mmdb"); err != nil {
+		t.Fatal(err)
+	}
+	if w.size != int64(len(db)) {
+		t.Errorf("got preallocated size %v, want %v", w.size, len(db))
+	}
+}
+
+type testPreallocWriter struct {
+	bytes.Buffer
+	size int64
+}
+
+func (w *testPreallocWriter) preallocate(size int64) error {
+	w.size = size
+	return nil
+}
+
+func TestUpdater_AuxiliaryFiles(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "GeoLite2-City_20200102/COPYRIGHT.txt", data: []byte("copyright")},
+		{name: "GeoLite2-City_20200102/GeoLite2-City.mmdb", data: db},
+		{name: "GeoLite2-City_20200102/LICENSE.txt", data: []byte("license")},
+		{name: "GeoLite2-City_20200102/README.txt", data: []byte("readme")},
+	} {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.data)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if _, err := gzw.Write(tarBuf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.SetArchive("GeoLite2-City", "tar.gz", buf.Bytes())
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	u := &Updater{
+		BaseURL:        ts.URL,
+		FileSystem:     fs,
+		AuxiliaryFiles: []string{"COPYRIGHT.txt", "LICENSE.txt", "NOTICE.txt"},
+	}
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if !bytes.Equal(fs.file(filename), db) {
+		t.Error("got invalid database")
+	}
+	for name, want := range map[string]string{
+		"COPYRIGHT.txt": "copyright",
+		"LICENSE.txt":   "license",
+	} {
+		if got := string(fs.file(filepath.FromSlash("/data/" + name))); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"README.txt", "NOTICE.txt"} {
+		if fs.file(filepath.FromSlash("/data/"+name)) != nil {
+			t.Errorf("got %s saved", name)
+		}
+	}
+
+	// the database file is not consumed as an auxiliary file
+	fs = newMemFileSystem()
+	u.FileSystem = fs
+	u.AuxiliaryFiles = []string{"GeoLite2-City.mmdb", "LICENSE.txt"}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fs.file(filename), db) {
+		t.Error("got invalid database")
+	}
+	if got := string(fs.file(filepath.FromSlash("/data/LICENSE.txt"))); got != "license" {
+		t.Errorf("got LICENSE.txt %q, want %q", got, "license")
+	}
+	if got := fs.file(filepath.FromSlash("/data/GeoLite2-City.mmdb")); got != nil {
+		t.Errorf("got GeoLite2-City.mmdb saved")
+	}
+}
+
+func TestUpdater_AcceptFunc(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	var calls int
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: fs,
+		AcceptFunc: func(newMetadata, oldMetadata *Metadata) bool {
+			calls++
+			return oldMetadata == nil || newMetadata.BuildEpoch.After(oldMetadata.BuildEpoch)
+		},
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+
+	// an older database is published
+	ts.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(-time.Hour)))
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if got := fs.file(filename); !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+	if calls != 2 {
+		t.Errorf("got %v calls, want 2", calls)
+	}
+	if got, want := fs.filenames(), []string{filepath.FromSlash("/data/GeoLite2-City.tar.gz.md5"), filename}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestUpdater_Suffix(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "GeoLite2-City_20200102/GeoLite2-City.mmdb",
+		Mode:     0644,
+		Size:     int64(len(db)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.SetArchive("GeoLite2-City", "tar", buf.Bytes())
+	ts.SetArchive("GeoLite2-City", "mmdb", db)
+
+	for _, suffix := range []string{"tar", "mmdb"} {
+		t.Run(suffix, func(t *testing.T) {
+			fs := newMemFileSystem()
+			filename := filepath.FromSlash("/data/city.mmdb")
+			u := &Updater{
+				BaseURL:    ts.URL,
+				Suffix:     suffix,
+				FileSystem: fs,
+			}
+
+			result, err := u.City(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			if !bytes.Equal(fs.file(filename), db) {
+				t.Error("got invalid database")
+			}
+			if fs.file(filepath.FromSlash("/data/GeoLite2-City."+suffix+".md5")) == nil {
+				t.Error("got no state file")
+			}
+
+			result, err = u.City(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Saved {
+				t.Error("got saved")
+			}
+		})
+	}
+
+	ts.SetArchive("GeoLite2-City", "zst", db)
+	_, err := (&Updater{
+		BaseURL:    ts.URL,
+		Suffix:     "zst",
+		FileSystem: newMemFileSystem(),
+	}).City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if err == nil {
+		t.Error("got no error for unsupported suffix")
+	}
+}
+
+func TestUpdater_multistreamGzip(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "GeoLite2-City_20200102/GeoLite2-City.mmdb",
+		Mode:     0644,
+		Size:     int64(len(db)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// compress the tar archive in two gzip members
+	var buf bytes.Buffer
+	data := tarBuf.Bytes()
+	for _, part := range [][]byte{data[:len(data)/2], data[len(data)/2:]} {
+		gzw := gzip.NewWriter(&buf)
+		if _, err := gzw.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.SetArchive("GeoLite2-City", "tar.gz", buf.Bytes())
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	result, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if !bytes.Equal(fs.file(filename), db) {
+		t.Error("got invalid database")
+	}
+}
+
+func TestUpdater_archiveLayout(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	for _, name := range []string{
+		"GeoLite2-City.mmdb",
+		"GeoLite2-City_20200102\\GeoLite2-City.mmdb",
+		"GeoLite2-City_20200102/geolite2-city.MMDB",
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, name, db))
+
+			fs := newMemFileSystem()
+			filename := filepath.FromSlash("/data/city.mmdb")
+			result, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			if !bytes.Equal(fs.file(filename), db) {
+				t.Error("got invalid database")
+			}
+		})
+	}
+}
+
+func TestUpdater_MinInterval(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{
+		BaseURL:     ts.URL,
+		MinInterval: time.Hour,
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	requests := len(ts.Requests())
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if got := len(ts.Requests()); got != requests {
+		t.Errorf("got %v requests, want %v", got, requests)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(ts.Requests()); got != requests+1 {
+		t.Errorf("got %v requests, want %v", got, requests+1)
+	}
+}
+
+func TestUpdater_missingDatabase(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	u := &Updater{BaseURL: ts.URL, FileSystem: fs}
+
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if fs.file(filename) == nil {
+		t.Error("got no database")
+	}
+}
+
+func TestUpdater_incompleteDownload(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	_, err := (&Updater{
+		BaseURL:    ts.URL,
+		FileSystem: fs,
+		Client: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				resp, err := http.DefaultTransport.RoundTrip(r)
+				if err != nil {
+					return nil, err
+				}
+				if r.URL.Query().Get("suffix") == "tar.gz" {
+					// announce more data than it is sent
+					resp.ContentLength += 10
+				}
+				return resp, nil
+			}),
+		},
+	}).City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if !errors.Is(err, ErrIncompleteDownload) {
+		t.Errorf("got error %v, want %v", err, ErrIncompleteDownload)
+	}
+	if got := fs.filenames(); len(got) != 0 {
+		t.Errorf("got files %q", got)
+	}
+}
+
+func TestUpdater_databaseNotInArchive(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	ts.SetArchive("GeoLite2-City", "tar.gz", testArchive(t, "GeoLite2-City_20200102/LICENSE.txt", []byte("license")))
+
+	fs := newMemFileSystem()
+	result, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if !errors.Is(err, ErrDatabaseNotInArchive) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseNotInArchive)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if got := fs.filenames(); len(got) != 0 {
+		t.Errorf("got files %q", got)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	archive := testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", db)
+
+	var buf bytes.Buffer
+	if err := Extract(bytes.NewReader(archive), "GeoLite2-City.mmdb", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	err := Extract(bytes.NewReader(archive), "GeoLite2-ASN.mmdb", ioutil.Discard)
+	if !errors.Is(err, ErrDatabaseNotInArchive) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseNotInArchive)
+	}
+
+	archive = testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", []byte("data"))
+	err = Extract(bytes.NewReader(archive), "GeoLite2-City.mmdb", ioutil.Discard)
+	if !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidDatabase)
+	}
+}
+
+func TestUpdater_nonRegularEntry(t *testing.T) {
+	ts := mmdbtest.NewServer(nil)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "GeoLite2-City_20200102/GeoLite2-City.mmdb",
+		Linkname: "/etc/passwd",
+		Mode:     0644,
+		Typeflag: tar.TypeSymlink,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.SetArchive("GeoLite2-City", "tar.gz", buf.Bytes())
+
+	fs := newMemFileSystem()
+	result, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filepath.FromSlash("/data/city.mmdb"))
+	if err == nil {
+		t.Error("got no error")
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if got := fs.filenames(); len(got) != 0 {
+		t.Errorf("got files %q", got)
+	}
+}
+
+func TestMatchEntry(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{name: "GeoLite2-City.mmdb", want: true},
+		{name: "GeoLite2-City_20200102/GeoLite2-City.mmdb", want: true},
+		{name: "GeoLite2-City_20200102\\GeoLite2-City.mmdb", want: true},
+		{name: "GeoLite2-City_20200102/GEOLITE2-CITY.MMDB", want: true},
+		{name: "GeoLite2-City_20200102/", want: false},
+		{name: "GeoLite2-City_20200102/LICENSE.txt", want: false},
+		{name: "GeoLite2-City_20200102/GeoLite2-City.mmdb.txt", want: false},
+	} {
+		if got := matchEntry(tc.name, "GeoLite2-City.mmdb"); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper implemented by a function.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// testArchive returns a gzipped tar archive with a single file.
+func testArchive(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
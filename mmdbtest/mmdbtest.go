@@ -0,0 +1,222 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+// Package mmdbtest provides a test server that serves MaxMind databases in
+// the same way as the MaxMind download endpoint, so that updates can be
+// tested without a license key or network access.
+package mmdbtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an HTTP test server that serves tar.gz archives of databases
+// and their MD5 sums by edition ID. Its URL should be set as the Updater
+// BaseURL. Any license key is accepted.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// archives are keyed by the edition ID and the download suffix
+	archives map[string][]byte
+	urls     []*url.URL
+}
+
+// NewServer starts a Server that serves archives constructed from databases
+// keyed by edition ID. The Server should be closed when it is not needed.
+func NewServer(databases map[string][]byte) *Server {
+	s := &Server{
+		archives: make(map[string][]byte),
+	}
+	for editionID, db := range databases {
+		s.SetDatabase(editionID, db)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetDatabase sets the database that is served for the edition, replacing
+// the previous one, so that updates can be tested.
+func (s *Server) SetDatabase(editionID string, db []byte) {
+	s.SetArchive(editionID, tarGzSuffix, Archive(editionID, db))
+}
+
+// SetArchive sets the archive that is served for the edition and the
+// download suffix, like zip for CSV editions, replacing the previous one.
+// It can be used to test archives with a different layout or malformed
+// ones.
+func (s *Server) SetArchive(editionID, suffix string, archive []byte) {
+	s.mu.Lock()
+	s.archives[editionID+"."+suffix] = archive
+	s.mu.Unlock()
+}
+
+// Archive returns the tar.gz archive that is served for the edition.
+func (s *Server) Archive(editionID string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.archives[editionID+"."+tarGzSuffix]
+}
+
+// Requests returns URLs of all received requests.
+func (s *Server) Requests() []*url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*url.URL(nil), s.urls...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	suffix := q.Get("suffix")
+
+	s.mu.Lock()
+	s.urls = append(s.urls, r.URL)
+	archive, ok := s.archives[q.Get("edition_id")+"."+strings.TrimSuffix(suffix, ".md5")]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(suffix, ".md5") {
+		fmt.Fprintf(w, "%x\n", md5.Sum(archive))
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+}
+
+// tarGzSuffix is the download suffix of archives set by SetDatabase.
+const tarGzSuffix = "tar.gz"
+
+// Archive returns a tar.gz archive with the database in the same layout as
+// MaxMind archives, in the directory named by the edition ID and the date.
+func Archive(editionID string, db []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	// writes to the buffer do not fail
+	_ = tw.WriteHeader(&tar.Header{
+		Name:     editionID + "_" + time.Now().UTC().Format("20060102") + "/" + editionID + ".mmdb",
+		Mode:     0644,
+		Size:     int64(len(db)),
+		Typeflag: tar.TypeReg,
+	})
+	_, _ = tw.Write(db)
+	_ = tw.Close()
+	_ = gzw.Close()
+	return buf.Bytes()
+}
+
+// Database returns a valid MaxMind DB database of the type, which is the
+// edition ID for MaxMind databases, with the build time. The database has
+// no data, so all lookups return no records.
+func Database(databaseType string, buildEpoch time.Time) []byte {
+	var buf bytes.Buffer
+	// search tree with one node and both 24 bit records pointing to the
+	// node count, which marks an empty record
+	buf.Write([]byte{0, 0, 1, 0, 0, 1})
+	// data section separator, followed by the empty data section
+	buf.Write(make([]byte, 16))
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&buf, map[string]interface{}{
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+		"build_epoch":                 uint64(buildEpoch.Unix()),
+		"database_type":               databaseType,
+		"description": map[string]interface{}{
+			"en": "Test " + databaseType + " database",
+		},
+		"ip_version":  uint64(6),
+		"languages":   []interface{}{"en"},
+		"node_count":  uint64(1),
+		"record_size": uint64(24),
+	})
+	return buf.Bytes()
+}
+
+// MaxMind DB data section field types used by encode.
+const (
+	typeString = 2
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+// encode writes the value in MaxMind DB data section format.
+func encode(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint64:
+		var b []byte
+		for x := v; x > 0; x >>= 8 {
+			b = append([]byte{byte(x)}, b...)
+		}
+		writeControl(buf, typeUint64, len(b))
+		buf.Write(b)
+	case map[string]interface{}:
+		writeControl(buf, typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	case []interface{}:
+		writeControl(buf, typeArray, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	default:
+		panic(fmt.Sprintf("mmdbtest: unsupported type %T", v))
+	}
+}
+
+// writeControl writes the control byte of the field with the type and the
+// size.
+func writeControl(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	if typ <= 7 {
+		ctrl = byte(typ) << 5
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		s := size - 285
+		extra = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		extra = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	buf.WriteByte(ctrl)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(extra)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdbtest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb"
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestServer(t *testing.T) {
+	buildEpoch := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	db := mmdbtest.Database("GeoLite2-City", buildEpoch)
+
+	s := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &mmdb.Updater{
+		BaseURL:    s.URL,
+		LicenseKey: "test",
+	}
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("got invalid database")
+	}
+
+	md, err := mmdb.ReadMetadata(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.DatabaseType != "GeoLite2-City" {
+		t.Errorf("got database type %q, want %q", md.DatabaseType, "GeoLite2-City")
+	}
+	if !md.BuildEpoch.Equal(buildEpoch) {
+		t.Errorf("got build epoch %v, want %v", md.BuildEpoch, buildEpoch)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+
+	s.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", buildEpoch.Add(24*time.Hour)))
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if got := len(s.Requests()); got != 5 {
+		t.Errorf("got %v requests, want %v", got, 5)
+	}
+}
+
+func TestServer_SetArchive(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	s := mmdbtest.NewServer(nil)
+	defer s.Close()
+	s.SetArchive("GeoLite2-City", "mmdb", db)
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &mmdb.Updater{
+		BaseURL:    s.URL,
+		LicenseKey: "test",
+		Suffix:     "mmdb",
+	}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("got invalid database")
+	}
+
+	u.Suffix = ""
+	if _, err := u.City(context.Background(), filename); !errors.Is(err, mmdb.ErrEditionNotFound) {
+		t.Errorf("got error %v, want %v", err, mmdb.ErrEditionNotFound)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// OpenAfterUpdate updates the database of the edition saved under filename
+// and calls the open function with the filename once the database file is
+// complete and valid, whether it is updated or not. The open function can be
+// used to open the database with a lookup library, for example:
+//
+//	var db *geoip2.Reader
+//	_, err := u.OpenAfterUpdate(ctx, filename, "GeoLite2-City", func(filename string) (err error) {
+//		db, err = geoip2.Open(filename)
+//		return err
+//	})
+//
+// The open function is not called if the update fails.
+func (u *Updater) OpenAfterUpdate(ctx context.Context, filename, editionID string, open func(filename string) error) (Result, error) {
+	result, err := u.Update(ctx, filename, editionID)
+	if err != nil {
+		return result, err
+	}
+	if _, err := u.readMetadata(u.fileSystem(), filename); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	if err := open(filename); err != nil {
+		return result, fmt.Errorf("open database: %w", err)
+	}
+	return result, nil
+}
+
+// Database keeps a database of the edition saved under a filename open and
+// replaces it with the updated one on Reload. Lookups with the open
+// database are safe for concurrent use with Reload.
+//
+// The file is replaced while the current reader is still open. On Windows,
+// this is possible only if the reader memory maps the file and closes its
+// handle, as the maxminddb-golang library does, in which case the replaced
+// file is moved aside until the reader is closed. Reload returns
+// ErrDestinationInUse for readers that keep the file open.
+type Database struct {
+	u         *Updater
+	filename  string
+	editionID string
+	open      func(filename string) (io.Closer, error)
+
+	mu     sync.RWMutex
+	reader io.Closer
+	closed bool
+}
+
+// OpenDatabase updates the database of the edition saved under filename and
+// opens it with the open function, in the same way as OpenAfterUpdate. The
+// open function returns a reader of a lookup library, for example:
+//
+//	db, err := u.OpenDatabase(ctx, filename, "GeoLite2-City", func(filename string) (io.Closer, error) {
+//		return geoip2.Open(filename)
+//	})
+//
+// The returned Database should be closed when it is not needed.
+func (u *Updater) OpenDatabase(ctx context.Context, filename, editionID string, open func(filename string) (io.Closer, error)) (*Database, error) {
+	d := &Database{
+		u:         u,
+		filename:  filename,
+		editionID: editionID,
+		open:      open,
+	}
+	if _, err := u.OpenAfterUpdate(ctx, filename, editionID, func(filename string) (err error) {
+		d.reader, err = open(filename)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload updates the database and, if a new one is saved, opens it and
+// replaces the current reader, which is closed after all lookups that use it
+// are done. If the update or the opening fails, the current reader is kept.
+func (d *Database) Reload(ctx context.Context) (Result, error) {
+	result, err := d.u.Update(ctx, d.filename, d.editionID)
+	if err != nil {
+		return result, err
+	}
+	if !result.Saved {
+		return result, nil
+	}
+	if _, err := d.u.readMetadata(d.u.fileSystem(), d.filename); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	reader, err := d.open(d.filename)
+	if err != nil {
+		return result, fmt.Errorf("open database: %w", err)
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		_ = reader.Close()
+		return result, ErrDatabaseClosed
+	}
+	previous := d.reader
+	d.reader = reader
+	d.mu.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			return result, fmt.Errorf("close previous database: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// Lookup calls the function with the current reader, which is not closed or
+// replaced until the function returns. The reader must not be used after
+// that.
+func (d *Database) Lookup(f func(reader io.Closer) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrDatabaseClosed
+	}
+	return f(d.reader)
+}
+
+// ErrDatabaseClosed is returned by Database Lookup and Reload after the
+// Database is closed.
+var ErrDatabaseClosed = errors.New("database closed")
+
+// Close closes the current reader.
+func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.reader.Close()
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_OpenAfterUpdate(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{BaseURL: ts.URL}
+
+	for _, wantSaved := range []bool{true, false} {
+		var md *Metadata
+		result, err := u.OpenAfterUpdate(context.Background(), filename, "GeoLite2-City", func(filename string) (err error) {
+			md, err = ReadMetadata(filename)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Saved != wantSaved {
+			t.Errorf("got saved %v, want %v", result.Saved, wantSaved)
+		}
+		if md == nil || md.DatabaseType != "GeoLite2-City" {
+			t.Errorf("got metadata %+v", md)
+		}
+	}
+
+	errOpen := errors.New("open error")
+	_, err = u.OpenAfterUpdate(context.Background(), filename, "GeoLite2-City", func(string) error {
+		return errOpen
+	})
+	if !errors.Is(err, errOpen) {
+		t.Errorf("got error %v, want %v", err, errOpen)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("invalid"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.OpenAfterUpdate(context.Background(), filename, "GeoLite2-City", func(string) error {
+		t.Error("open function called for invalid database")
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidDatabase)
+	}
+}
+
+func TestUpdater_OpenDatabase(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	var readers []*testReader
+	db, err := (&Updater{BaseURL: ts.URL}).OpenDatabase(context.Background(), filename, "GeoLite2-City", func(filename string) (io.Closer, error) {
+		md, err := ReadMetadata(filename)
+		if err != nil {
+			return nil, err
+		}
+		r := &testReader{md: md}
+		readers = append(readers, r)
+		return r, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buildEpoch := func() (buildEpoch time.Time) {
+		if err := db.Lookup(func(r io.Closer) error {
+			buildEpoch = r.(*testReader).md.BuildEpoch
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return buildEpoch
+	}
+
+	if got := buildEpoch(); !got.Equal(testBuildEpoch) {
+		t.Errorf("got build epoch %v, want %v", got, testBuildEpoch)
+	}
+
+	result, err := db.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if len(readers) != 1 {
+		t.Errorf("got %v opened readers, want %v", len(readers), 1)
+	}
+
+	newBuildEpoch := testBuildEpoch.Add(time.Hour)
+	ts.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", newBuildEpoch))
+
+	result, err = db.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if got := buildEpoch(); !got.Equal(newBuildEpoch) {
+		t.Errorf("got build epoch %v, want %v", got, newBuildEpoch)
+	}
+	if len(readers) != 2 || !readers[0].closed || readers[1].closed {
+		t.Errorf("got readers %+v", readers)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !readers[1].closed {
+		t.Error("got reader not closed")
+	}
+	if err := db.Lookup(func(io.Closer) error { return nil }); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseClosed)
+	}
+}
+
+func TestDatabase_concurrentLookup(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	db, err := (&Updater{BaseURL: ts.URL}).OpenDatabase(context.Background(), filename, "GeoLite2-City", func(filename string) (io.Closer, error) {
+		md, err := ReadMetadata(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &testReader{md: md}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var last time.Time
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := db.Lookup(func(r io.Closer) error {
+					tr := r.(*testReader)
+					if tr.closed {
+						return errors.New("closed reader")
+					}
+					if tr.md.BuildEpoch.Before(last) {
+						return fmt.Errorf("got build epoch %v before %v", tr.md.BuildEpoch, last)
+					}
+					last = tr.md.BuildEpoch
+					return nil
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	buildEpoch := testBuildEpoch
+	for i := 0; i < 10; i++ {
+		buildEpoch = buildEpoch.Add(time.Hour)
+		ts.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", buildEpoch))
+		result, err := db.Reload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Saved {
+			t.Error("got not saved")
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := db.Lookup(func(r io.Closer) error {
+		if got := r.(*testReader).md.BuildEpoch; !got.Equal(buildEpoch) {
+			t.Errorf("got build epoch %v, want %v", got, buildEpoch)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testReader struct {
+	md     *Metadata
+	closed bool
+}
+
+func (r *testReader) Close() error {
+	r.closed = true
+	return nil
+}
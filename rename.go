@@ -0,0 +1,72 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Number of rename attempts and the delay before the first retry, that is
+// doubled for every next one, when the file can not be renamed because it
+// is temporarily used by another process.
+var (
+	renameAttempts   = 5
+	renameRetryDelay = 50 * time.Millisecond
+)
+
+// retryRename renames the file with the rename function, retrying it while
+// the returned error is temporary.
+func retryRename(rename func(oldpath, newpath string) error, temporary func(err error) bool, oldpath, newpath string) (err error) {
+	for attempt := 0; attempt < renameAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(renameRetryDelay << uint(attempt-1))
+		}
+		err = rename(oldpath, newpath)
+		if err == nil || !temporary(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// oldSuffix is appended to the name of the file that is moved aside by
+// renameAside, as it can not be replaced while it is in use.
+const oldSuffix = ".old"
+
+// renameAside replaces the file at newpath with the one at oldpath in the
+// same way as retryRename. If the file at newpath can not be replaced after
+// all attempts, it is moved aside to the name with oldSuffix and the file at
+// oldpath is renamed in its place. This is possible on Windows for memory
+// mapped files, whose file handles are closed, but not for files that are
+// opened without the delete sharing mode, in which case ErrDestinationInUse
+// is returned if the inUse function reports the error of moving aside. Any
+// other error of moving aside, like a missing permission, is not reported,
+// but the original one. The moved aside file is removed if it is not used
+// anymore, otherwise it is removed by the next rename or by Updater Cleanup.
+func renameAside(rename func(oldpath, newpath string) error, remove func(name string) error, temporary, inUse func(err error) bool, oldpath, newpath string) error {
+	err := retryRename(rename, temporary, oldpath, newpath)
+	if err == nil || !temporary(err) {
+		return err
+	}
+
+	asidePath := newpath + oldSuffix
+	// the file moved aside by a previous rename may not be used anymore
+	_ = remove(asidePath)
+	if aerr := rename(newpath, asidePath); aerr != nil {
+		if inUse(aerr) {
+			return fmt.Errorf("%w: %v", ErrDestinationInUse, aerr)
+		}
+		return err
+	}
+	if err := rename(oldpath, newpath); err != nil {
+		_ = rename(asidePath, newpath)
+		return err
+	}
+	// removal fails while the file is still memory mapped
+	_ = remove(asidePath)
+	return nil
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package mmdb
+
+import "os"
+
+// rename replaces the file at newpath with the one at oldpath.
+func rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestRetryRename(t *testing.T) {
+	defer func(d time.Duration) { renameRetryDelay = d }(renameRetryDelay)
+	renameRetryDelay = time.Millisecond
+
+	errTemporary := errors.New("temporary")
+	errPermanent := errors.New("permanent")
+
+	for _, tc := range []struct {
+		name         string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "success",
+			wantAttempts: 1,
+		},
+		{
+			name:         "temporary",
+			errs:         []error{errTemporary, errTemporary},
+			wantAttempts: 3,
+		},
+		{
+			name:         "permanent",
+			errs:         []error{errPermanent},
+			wantErr:      errPermanent,
+			wantAttempts: 1,
+		},
+		{
+			name:         "exhausted",
+			errs:         []error{errTemporary, errTemporary, errTemporary, errTemporary, errTemporary, errTemporary},
+			wantErr:      errTemporary,
+			wantAttempts: renameAttempts,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int
+			err := retryRename(func(oldpath, newpath string) error {
+				attempts++
+				if attempts <= len(tc.errs) {
+					return tc.errs[attempts-1]
+				}
+				return nil
+			}, func(err error) bool {
+				return err == errTemporary
+			}, "old", "new")
+			if err != tc.wantErr {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("got %v attempts, want %v", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRenameAside(t *testing.T) {
+	defer func(d time.Duration) { renameRetryDelay = d }(renameRetryDelay)
+	renameRetryDelay = time.Millisecond
+
+	errInUse := errors.New("in use")
+	errDenied := errors.New("access denied")
+
+	for _, tc := range []struct {
+		name string
+		// mapped files can be renamed, but not replaced or removed
+		mapped []string
+		// opened files can not be renamed, replaced or removed
+		opened []string
+		// denied files can not be changed as there is no permission
+		denied []string
+		// the file moved aside by a previous rename exists
+		previous  bool
+		wantErr   error
+		wantFiles map[string]string
+	}{
+		{
+			name:      "not used",
+			wantFiles: map[string]string{"db": "new"},
+		},
+		{
+			name:      "mapped",
+			mapped:    []string{"db"},
+			wantFiles: map[string]string{"db": "new", "db.old": "old"},
+		},
+		{
+			name:      "mapped previous",
+			mapped:    []string{"db"},
+			previous:  true,
+			wantFiles: map[string]string{"db": "new", "db.old": "old"},
+		},
+		{
+			name:      "opened",
+			opened:    []string{"db"},
+			wantErr:   ErrDestinationInUse,
+			wantFiles: map[string]string{"db": "old", "db.tmp": "new"},
+		},
+		{
+			name:      "mapped previous in use",
+			mapped:    []string{"db", "db.old"},
+			previous:  true,
+			wantErr:   errDenied,
+			wantFiles: map[string]string{"db": "old", "db.old": "previous", "db.tmp": "new"},
+		},
+		{
+			name:      "permission denied",
+			denied:    []string{"db"},
+			wantErr:   errDenied,
+			wantFiles: map[string]string{"db": "old", "db.tmp": "new"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{"db": "old", "db.tmp": "new"}
+			if tc.previous {
+				files["db.old"] = "previous"
+			}
+			mapped := make(map[string]bool)
+			for _, name := range tc.mapped {
+				mapped[name] = true
+			}
+			opened := make(map[string]bool)
+			for _, name := range tc.opened {
+				opened[name] = true
+			}
+			denied := make(map[string]bool)
+			for _, name := range tc.denied {
+				denied[name] = true
+			}
+
+			rename := func(oldpath, newpath string) error {
+				if opened[oldpath] || opened[newpath] {
+					return errInUse
+				}
+				if denied[oldpath] || denied[newpath] || mapped[newpath] {
+					return errDenied
+				}
+				files[newpath] = files[oldpath]
+				delete(files, oldpath)
+				mapped[newpath] = mapped[oldpath]
+				delete(mapped, oldpath)
+				return nil
+			}
+			remove := func(name string) error {
+				if opened[name] {
+					return errInUse
+				}
+				if denied[name] || mapped[name] {
+					return errDenied
+				}
+				delete(files, name)
+				return nil
+			}
+			temporary := func(err error) bool {
+				return err == errInUse || err == errDenied
+			}
+			inUse := func(err error) bool {
+				return err == errInUse
+			}
+
+			err := renameAside(rename, remove, temporary, inUse, "db.tmp", "db")
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(files, tc.wantFiles) {
+				t.Errorf("got files %v, want %v", files, tc.wantFiles)
+			}
+		})
+	}
+}
+
+func TestUpdater_destinationOpen(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files opened by os.Open can not be replaced on windows")
+	}
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{BaseURL: ts.URL}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(time.Hour))
+	ts.SetDatabase("GeoLite2-City", db)
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+}
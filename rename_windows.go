@@ -0,0 +1,51 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Windows errors returned when the file is used by another process.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// rename replaces the file at newpath with the one at oldpath. On Windows,
+// os.Rename replaces the existing file with MoveFileEx, but it fails while
+// the file is opened by another process without the delete sharing mode, for
+// example by an antivirus scanner or a database reader, so the rename is
+// retried. A memory mapped file can not be replaced, but it can be renamed,
+// so it is moved aside as the last resort. ErrDestinationInUse is returned
+// if the file is still opened, and readers must close it before the update.
+func rename(oldpath, newpath string) error {
+	return renameAside(os.Rename, os.Remove, isTemporaryRenameError, isSharingError, oldpath, newpath)
+}
+
+// isSharingError reports whether the error is returned because the file is
+// used by another process.
+func isSharingError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == errorSharingViolation || errno == errorLockViolation
+}
+
+// isTemporaryRenameError reports whether the rename may succeed if it is
+// retried. Access is denied also when the replaced file is memory mapped or
+// it is pending deletion, which can not be distinguished from a missing
+// permission.
+func isTemporaryRenameError(err error) bool {
+	if isSharingError(err) {
+		return true
+	}
+	var errno syscall.Errno
+	return errors.As(err, &errno) && errno == syscall.ERROR_ACCESS_DENIED
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestIsSharingError(t *testing.T) {
+	for _, tc := range []struct {
+		err           error
+		wantSharing   bool
+		wantTemporary bool
+	}{
+		{err: nil},
+		{err: errors.New("test")},
+		{err: syscall.ERROR_FILE_NOT_FOUND},
+		{err: syscall.ERROR_ACCESS_DENIED, wantTemporary: true},
+		{err: errorSharingViolation, wantSharing: true, wantTemporary: true},
+		{err: errorLockViolation, wantSharing: true, wantTemporary: true},
+		{err: &os.LinkError{Op: "rename", Old: "old", New: "new", Err: errorSharingViolation}, wantSharing: true, wantTemporary: true},
+		{err: fmt.Errorf("rename: %w", syscall.ERROR_ACCESS_DENIED), wantTemporary: true},
+	} {
+		if got := isSharingError(tc.err); got != tc.wantSharing {
+			t.Errorf("%v: got sharing error %v, want %v", tc.err, got, tc.wantSharing)
+		}
+		if got := isTemporaryRenameError(tc.err); got != tc.wantTemporary {
+			t.Errorf("%v: got temporary error %v, want %v", tc.err, got, tc.wantTemporary)
+		}
+	}
+}
+
+func TestUpdater_destinationOpen_windows(t *testing.T) {
+	defer func(d time.Duration) { renameRetryDelay = d }(renameRetryDelay)
+	renameRetryDelay = time.Millisecond
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{BaseURL: ts.URL}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// os.Open does not use the delete sharing mode
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ts.SetDatabase("GeoLite2-City", mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(time.Hour)))
+
+	if _, err := u.City(context.Background(), filename); !errors.Is(err, ErrDestinationInUse) {
+		t.Fatalf("got error %v, want %v", err, ErrDestinationInUse)
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got database %q, want %q", got, want)
+	}
+}
+
+func TestUpdater_destinationMapped_windows(t *testing.T) {
+	defer func(d time.Duration) { renameRetryDelay = d }(renameRetryDelay)
+	renameRetryDelay = time.Millisecond
+
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+
+	u := &Updater{BaseURL: ts.URL}
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is memory mapped and its handle closed, as database readers
+	// usually do
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, 0, 0, nil)
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	addr, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_READ, 0, 0, 0)
+	if err != nil {
+		syscall.CloseHandle(mapping)
+		f.Close()
+		t.Fatal(err)
+	}
+	unmap := func() {
+		syscall.UnmapViewOfFile(addr)
+		syscall.CloseHandle(mapping)
+	}
+	defer func() { unmap() }()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch.Add(time.Hour))
+	ts.SetDatabase("GeoLite2-City", db)
+
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("got database %q, want %q", got, db)
+	}
+
+	// the mapped file is moved aside until it is unmapped
+	if _, err := os.Stat(filename + oldSuffix); err != nil {
+		t.Fatal(err)
+	}
+	unmap()
+	unmap = func() {}
+	if err := u.Cleanup(filename, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + oldSuffix); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
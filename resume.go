@@ -0,0 +1,190 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errRangeNotSatisfiable is returned by getRange on Range Not Satisfiable
+// response.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// errUnexpectedContentRange is returned when the Partial Content response
+// does not start from the requested offset.
+var errUnexpectedContentRange = errors.New("unexpected content range")
+
+// partFilename returns the name of the file where the tar archive is
+// downloaded with Resume option. The MD5 sum of the archive is saved in the
+// file with the same name and an additional ".md5" extension.
+func (u *Updater) partFilename(filename, editionID string) string {
+	return filepath.Join(u.stateDir(filename), editionID+"."+u.suffix()+".part")
+}
+
+// downloadResumable downloads the tar archive of the edition to the partial
+// file, continuing the download of the archive with the same checksum from
+// the previous update, if there is one. The content of the database file
+// from the complete archive is written to the writer in the same way as with
+// download. Partial files are removed once the archive is complete. If
+// archive is not nil, the complete archive is written to it.
+func (u *Updater) downloadResumable(ctx context.Context, fs FileSystem, partFilename string, w, archive io.Writer, editionID, dbname string, checksum []byte, preflight func(header http.Header, size int64) error) (md *Metadata, n int64, err error) {
+	md5Filename := partFilename + ".md5"
+
+	if err := fs.MkdirAll(filepath.Dir(partFilename), u.dirMode()); err != nil {
+		return nil, 0, fmt.Errorf("create state directory: %w", err)
+	}
+
+	var offset int64
+	if data, err := readFile(fs, md5Filename); err == nil && bytes.Equal(firstLine(data), checksum) {
+		if info, err := fs.Stat(partFilename); err == nil {
+			offset = info.Size()
+		}
+	}
+	if offset == 0 {
+		if err := writeFile(fs, md5Filename, append(append([]byte(nil), checksum...), '\n'), u.fileMode()); err != nil {
+			return nil, 0, fmt.Errorf("write part md5 file: %w", err)
+		}
+	}
+
+	if err := u.fetchPart(ctx, fs, partFilename, editionID, offset, preflight); err != nil {
+		return nil, 0, err
+	}
+
+	// the archive is complete, so the partial files are not needed after the
+	// extraction, even if it fails
+	defer func() {
+		_ = fs.Remove(partFilename)
+		_ = fs.Remove(md5Filename)
+	}()
+
+	f, err := fs.OpenFile(partFilename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open part file: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archive != nil {
+		r = io.TeeReader(f, archive)
+	}
+	return u.extract(r, w, u.suffix(), dbname, checksum)
+}
+
+// fetchPart appends the tar archive content from the offset to the partial
+// file until the whole archive is downloaded. Failed requests and
+// interrupted downloads are continued from the last received byte up to the
+// number of Retries in total, as requests are made without their own
+// retries.
+func (u *Updater) fetchPart(ctx context.Context, fs FileSystem, partFilename, editionID string, offset int64, preflight func(header http.Header, size int64) error) error {
+	var started bool
+	var startErr error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := u.wait(ctx, attempt); err != nil {
+				return err
+			}
+		}
+		r, temporary, err := u.getRange(ctx, http.MethodGet, editionID, u.suffix(), time.Time{}, offset, 0)
+		if err != nil {
+			if errors.Is(err, errRangeNotSatisfiable) {
+				// the partial file is already complete, or it is not valid
+				// and the checksum mismatch is returned on extraction
+				return nil
+			}
+			err = fmt.Errorf("get tar: %w", err)
+			if !temporary || attempt >= u.Retries || (ctx != nil && ctx.Err() != nil) {
+				return err
+			}
+			u.Hooks.retry(editionID, attempt+1, err)
+			continue
+		}
+
+		offset, err = u.writePart(fs, partFilename, r, editionID, offset, func(header http.Header, size int64) error {
+			if started {
+				return nil
+			}
+			started = true
+			if preflight != nil {
+				if startErr = preflight(header, size); startErr != nil {
+					return startErr
+				}
+			}
+			u.Hooks.downloadStart(editionID, size)
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		if startErr != nil {
+			return startErr
+		}
+		if errors.Is(err, errUnexpectedContentRange) {
+			offset = 0
+		}
+		if attempt >= u.Retries || (ctx != nil && ctx.Err() != nil) {
+			return err
+		}
+		u.Hooks.retry(editionID, attempt+1, err)
+	}
+}
+
+// writePart writes the response body to the partial file and returns the
+// new size of the file. The start function is called with the response
+// header and the total size of the archive before the body is read.
+func (u *Updater) writePart(fs FileSystem, partFilename string, r *http.Response, editionID string, offset int64, start func(header http.Header, size int64) error) (int64, error) {
+	defer r.Body.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if r.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(r.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return offset, fmt.Errorf("%w %q", errUnexpectedContentRange, r.Header.Get("Content-Range"))
+		}
+	} else {
+		// the whole archive is sent
+		offset = 0
+		flag |= os.O_TRUNC
+	}
+
+	total := int64(-1)
+	if r.ContentLength >= 0 {
+		total = offset + r.ContentLength
+	}
+	if err := start(r.Header, total); err != nil {
+		return offset, err
+	}
+
+	f, err := fs.OpenFile(partFilename, flag, u.fileMode())
+	if err != nil {
+		return offset, fmt.Errorf("open part file: %w", err)
+	}
+
+	body, report := u.countBody(editionID, r.Body)
+	defer report()
+	if u.Progress != nil {
+		body = &progressReader{r: body, n: offset, total: total, f: u.Progress}
+	}
+	n, err := u.copy(f, body)
+	offset += n
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("close part file: %w", cerr)
+	}
+	if err != nil {
+		return offset, fmt.Errorf("download tar: %w", err)
+	}
+	if err := checkLength(r, n); err != nil {
+		return offset, err
+	}
+	return offset, nil
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_Resume(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": db,
+	})
+	defer ts.Close()
+
+	archive := ts.Archive("GeoLite2-City")
+	half := len(archive) / 2
+
+	var mu sync.Mutex
+	var ranges []string
+	interrupt := true
+	handler := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") != "tar.gz" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		i := interrupt
+		mu.Unlock()
+		if i && r.Header.Get("Range") == "" {
+			// send only a half of the archive
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			_, _ = w.Write(archive[:half])
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "city.mmdb")
+	partFilename := filepath.Join(dir, "GeoLite2-City.tar.gz.part")
+
+	u := &Updater{
+		BaseURL: ts.URL,
+		Resume:  true,
+	}
+
+	// interrupted download keeps the partial archive
+	result, err := u.City(context.Background(), filename)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	part, err := ioutil.ReadFile(partFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, archive[:half]) {
+		t.Errorf("got part file of %v bytes, want %v", len(part), half)
+	}
+
+	// the next update continues the download
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("got invalid database")
+	}
+	for _, name := range []string{partFilename, partFilename + ".md5"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("got file %s, error %v", name, err)
+		}
+	}
+
+	wantRanges := []string{"", "bytes=" + strconv.Itoa(half) + "-"}
+	if !reflect.DeepEqual(ranges, wantRanges) {
+		t.Errorf("got ranges %q, want %q", ranges, wantRanges)
+	}
+
+	// interrupted download is continued within the same update on retry
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	ranges = nil
+	mu.Unlock()
+	u.Force = true
+	u.Retries = 1
+	u.RetryBackoff = 1
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if !reflect.DeepEqual(ranges, wantRanges) {
+		t.Errorf("got ranges %q, want %q", ranges, wantRanges)
+	}
+}
+
+func TestUpdater_Resume_retries(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	archive := ts.Archive("GeoLite2-City")
+
+	var mu sync.Mutex
+	var requests int
+	handler := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") != "tar.gz" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		// server errors alternate with interrupted downloads
+		if n%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		_, _ = w.Write(archive[:len(archive)/2])
+	})
+
+	var retries int
+	u := &Updater{
+		BaseURL:      ts.URL,
+		Resume:       true,
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+		FileSystem:   newMemFileSystem(),
+		Hooks: Hooks{
+			OnRetry: func(editionID string, attempt int, err error) {
+				retries++
+			},
+		},
+	}
+	if _, err := u.City(context.Background(), filepath.FromSlash("/data/city.mmdb")); err == nil {
+		t.Fatal("got no error")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// requests are retried only by the resume loop
+	if requests != 3 {
+		t.Errorf("got %v archive requests, want %v", requests, 3)
+	}
+	if retries != 2 {
+		t.Errorf("got %v retries, want %v", retries, 2)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Run updates the database of the edition saved under filename immediately
+// and then periodically on every interval, until the context is done. The
+// function f, if it is not nil, is called with the result and the error of
+// every update. The context error is returned. With Jitter option, every
+// update, including the first one, is delayed by a random duration.
+// ErrInvalidInterval is returned if the interval is not positive. Unlike for
+// other functions, the context must not be nil, as Run would never return.
+func (u *Updater) Run(ctx context.Context, interval time.Duration, filename, editionID string, f func(Result, error)) error {
+	if ctx == nil {
+		return errors.New("nil context")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("%w %v", ErrInvalidInterval, interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := u.sleepJitter(ctx); err != nil {
+			return err
+		}
+		result, err := u.Update(ctx, filename, editionID)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if f != nil {
+			f(result, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// jitterRand is the source of random delays for the Jitter option, guarded
+// by the mutex as it is not safe for concurrent use.
+var (
+	jitterRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandMu sync.Mutex
+)
+
+// sleepJitter waits for a random duration up to Jitter, or until the
+// context is done, returning the context error.
+func (u *Updater) sleepJitter(ctx context.Context) error {
+	if u.Jitter <= 0 {
+		return nil
+	}
+	jitterRandMu.Lock()
+	d := time.Duration(jitterRand.Int63n(int64(u.Jitter)))
+	jitterRandMu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_Run(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: newMemFileSystem(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var saved []bool
+	err := u.Run(ctx, time.Millisecond, filepath.FromSlash("/data/city.mmdb"), "GeoLite2-City", func(result Result, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		saved = append(saved, result.Saved)
+		if len(saved) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if want := []bool{true, false, false}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("got saved %v, want %v", saved, want)
+	}
+}
+
+func TestUpdater_Run_jitter(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: newMemFileSystem(),
+		Jitter:     5 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var count int
+	err := u.Run(ctx, time.Millisecond, filepath.FromSlash("/data/city.mmdb"), "GeoLite2-City", func(result Result, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		count++
+		if count == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if count != 3 {
+		t.Errorf("got %v updates, want %v", count, 3)
+	}
+
+	// canceled while waiting for the first update
+	u.Jitter = time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := u.Run(ctx, time.Hour, filepath.FromSlash("/data/city.mmdb"), "GeoLite2-City", nil); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := len(ts.Requests()); got != 4 {
+		t.Errorf("got %v requests, want %v", got, 4)
+	}
+}
+
+func TestUpdater_Run_invalidInterval(t *testing.T) {
+	var requests int
+	u := &Updater{
+		Client: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				requests++
+				return nil, errors.New("unexpected request")
+			}),
+		},
+		FileSystem: newMemFileSystem(),
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		err := u.Run(context.Background(), interval, filepath.FromSlash("/data/city.mmdb"), "GeoLite2-City", nil)
+		if !errors.Is(err, ErrInvalidInterval) {
+			t.Errorf("interval %v: got error %v, want %v", interval, err, ErrInvalidInterval)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("got %v requests, want none", requests)
+	}
+}
+
+func TestUpdater_Run_nilContext(t *testing.T) {
+	var requests int
+	u := &Updater{
+		Client: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				requests++
+				return nil, errors.New("unexpected request")
+			}),
+		},
+		FileSystem: newMemFileSystem(),
+	}
+	if err := u.Run(nil, time.Hour, filepath.FromSlash("/data/city.mmdb"), "GeoLite2-City", nil); err == nil {
+		t.Error("got no error")
+	}
+	if requests != 0 {
+		t.Errorf("got %v requests, want none", requests)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The state file holds the information about the last saved database that
+// is used for update checks. By default, it is the MD5 sum of the archive,
+// as published by MaxMind. With SHA256 option, the state file holds
+// two lines, the SHA256 sum of the published MD5 sum, and the SHA256 sum of
+// the extracted database.
+
+// stateDir returns the directory where state files are saved for the
+// database saved under filename.
+func (u *Updater) stateDir(filename string) string {
+	if u.StateDir != "" {
+		return u.StateDir
+	}
+	return filepath.Dir(filename)
+}
+
+// MD5Filename returns the name of the file in the directory where the MD5
+// sum of the edition tar.gz archive is saved for update checks. The
+// directory is the one where the database is saved, or Updater StateDir, if
+// it is set.
+func MD5Filename(dir, editionID string) string {
+	return md5Filename(dir, editionID, tarGzSuffix)
+}
+
+// md5Filename returns the name of the MD5 sum file in the directory for the
+// edition archive with the suffix.
+func md5Filename(dir, editionID, suffix string) string {
+	return filepath.Join(dir, editionID+"."+suffix+".md5")
+}
+
+// md5Filename returns the name of the file where MD5 sum of the archive with
+// the suffix is saved for the database saved under filename.
+func (u *Updater) md5Filename(filename, editionID, suffix string) string {
+	return md5Filename(u.stateDir(filename), editionID, suffix)
+}
+
+// stateFilename returns the name of the state file for the database saved
+// under filename.
+func (u *Updater) stateFilename(filename, editionID, suffix string) string {
+	if u.SHA256 {
+		return filepath.Join(u.stateDir(filename), editionID+".sha256")
+	}
+	return u.md5Filename(filename, editionID, suffix)
+}
+
+// stateKey returns the value saved in the state file for the published MD5
+// sum of the tar archive.
+func (u *Updater) stateKey(checksum []byte) []byte {
+	if u.SHA256 {
+		return sha256Hex(checksum)
+	}
+	return checksum
+}
+
+// previousMD5 returns the normalized PreviousMD5 of the edition, or nil if
+// it is not set.
+func (u *Updater) previousMD5(editionID string) []byte {
+	md5sum := normalizeMD5([]byte(u.PreviousMD5[editionID]))
+	if len(md5sum) == 0 {
+		return nil
+	}
+	return md5sum
+}
+
+// readState returns the state key saved by the previous update, or nil if
+// there is no state file or with Stateless option. With SHA256 option, the
+// MD5 sum file saved without it is used if there is no SHA256 state file.
+// The legacy MD5 sum file is used for tar.gz archives if there is no other
+// state file.
+// The state key for the PreviousMD5 of the edition is returned if it is
+// set.
+func (u *Updater) readState(fs FileSystem, filename, editionID, suffix string) ([]byte, error) {
+	if previous := u.previousMD5(editionID); previous != nil {
+		return u.stateKey(previous), nil
+	}
+	if u.Stateless {
+		return nil, nil
+	}
+	data, err := readFile(fs, u.stateFilename(filename, editionID, suffix))
+	if err == nil {
+		return firstLine(data), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if u.SHA256 {
+		data, err = readFile(fs, u.md5Filename(filename, editionID, suffix))
+		if err == nil {
+			return sha256Hex(firstLine(data)), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if suffix != tarGzSuffix {
+		return nil, nil
+	}
+	data, err = readFile(fs, legacyMD5Filename(filename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return u.stateKey(firstLine(data)), nil
+}
+
+// legacyMD5Filename returns the name of the MD5 sum file that was saved by
+// versions before state files were named by the edition ID, in the
+// directory of the database. The file was shared by all editions in the
+// directory and it holds the MD5 sum of the last saved one.
+func legacyMD5Filename(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "geoip_download")
+}
+
+// migrateLegacyState saves the state file of the edition if there is none
+// and the legacy MD5 sum file has the checksum of the current archive, so
+// that the legacy file can be removed by Cleanup without downloading the
+// database again.
+func (u *Updater) migrateLegacyState(fs FileSystem, filename, editionID string, checksum []byte) error {
+	if u.Stateless || u.suffix() != tarGzSuffix || u.previousMD5(editionID) != nil {
+		return nil
+	}
+	if _, err := fs.Stat(u.stateFilename(filename, editionID, tarGzSuffix)); !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	data, err := readFile(fs, legacyMD5Filename(filename))
+	if err != nil || !bytes.Equal(firstLine(data), checksum) {
+		return nil
+	}
+	return u.writeState(fs, filename, editionID, tarGzSuffix, checksum, nil)
+}
+
+// writeState saves the state file after the database is saved. The dbSum
+// is the SHA256 sum of the database, used only with SHA256 option, if it is
+// not nil. Nothing is saved with Stateless option.
+func (u *Updater) writeState(fs FileSystem, filename, editionID, suffix string, checksum, dbSum []byte) error {
+	if u.Stateless {
+		return nil
+	}
+	stateFilename := u.stateFilename(filename, editionID, suffix)
+	if err := fs.MkdirAll(filepath.Dir(stateFilename), u.dirMode()); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+
+	data := u.stateKey(checksum)
+	if u.SHA256 && dbSum != nil {
+		data = []byte(fmt.Sprintf("%s\n%x\n", data, dbSum))
+	}
+	if err := writeFile(fs, stateFilename, data, u.fileMode()); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+
+	if u.SHA256 {
+		// remove MD5 sum file saved without SHA256 option
+		if err := fs.Remove(u.md5Filename(filename, editionID, suffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove md5 file: %w", err)
+		}
+	}
+
+	if setTestM5Filename != nil {
+		setTestM5Filename(stateFilename)
+	}
+	return nil
+}
+
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return bytes.TrimSpace(data)
+}
+
+func sha256Hex(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// ErrNoDatabaseChecksum is returned by Verify when the state file does not
+// contain the checksum of the database.
+var ErrNoDatabaseChecksum = errors.New("no database checksum")
+
+// Verify checks, without any network requests, that the database of the
+// edition saved under filename is the same as the one that was saved by the
+// update. The SHA256 sum of the database is compared with the one saved in
+// the state file with SHA256 option, which must be set.
+// ErrChecksumMismatch is returned if the database is changed.
+func (u *Updater) Verify(filename, editionID string) error {
+	if !u.SHA256 {
+		return fmt.Errorf("sha256 option is not set: %w", ErrNoDatabaseChecksum)
+	}
+	fs := u.fileSystem()
+
+	data, err := readFile(fs, u.stateFilename(filename, editionID, u.suffix()))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("state file not found: %w", ErrNoDatabaseChecksum)
+		}
+		return fmt.Errorf("read state file: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) < 2 {
+		return ErrNoDatabaseChecksum
+	}
+	want := string(bytes.TrimSpace(lines[1]))
+
+	f, err := fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open db file: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if u.GzipOutput {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("gzip reader: %w", err)
+		}
+		r = gzr
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("read db file: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("db sha256 %s, expected %s: %w", got, want, ErrChecksumMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_SHA256(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mmdb_"+t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "city.mmdb")
+	md5Filename := filepath.Join(dir, "GeoLite2-City.tar.gz.md5")
+	sha256Filename := filepath.Join(dir, "GeoLite2-City.sha256")
+
+	// update without SHA256 option
+	if _, err := (&Updater{BaseURL: ts.URL}).City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{
+		BaseURL: ts.URL,
+		SHA256:  true,
+	}
+
+	// MD5 sum file is used for the check
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+
+	newDB := mmdbtest.Database("GeoLite2-City", testBuildEpoch.AddDate(0, 0, 7))
+	ts.SetDatabase("GeoLite2-City", newDB)
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("expected file to be saved, but it is not")
+	}
+
+	if _, err := os.Stat(md5Filename); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist error", err)
+	}
+	got, err := ioutil.ReadFile(sha256Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiveMD5 := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+	want := fmt.Sprintf("%x\n%x\n", sha256.Sum256([]byte(archiveMD5)), sha256.Sum256(newDB))
+	if string(got) != want {
+		t.Errorf("got state file %q, want %q", got, want)
+	}
+
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("expected file not to be saved, but it is")
+	}
+}
+
+func TestUpdater_Stateless(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		Stateless:  true,
+		Resume:     true,
+		FileSystem: fs,
+	}
+	for i := 0; i < 2; i++ {
+		result, err := u.City(context.Background(), filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Saved {
+			t.Errorf("update %v: got not saved", i)
+		}
+		if got, want := fs.filenames(), []string{filename}; !reflect.DeepEqual(got, want) {
+			t.Errorf("update %v: got files %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestUpdater_PreviousMD5(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+		"GeoLite2-ASN":  mmdbtest.Database("GeoLite2-ASN", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	asnFilename := filepath.FromSlash("/data/asn.mmdb")
+
+	u := &Updater{
+		BaseURL:   ts.URL,
+		Stateless: true,
+		PreviousMD5: map[string]string{
+			"GeoLite2-City": "d41d8cd98f00b204e9800998ecf8427e",
+		},
+		FileSystem: fs,
+	}
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+	if want := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City"))); result.MD5 != want {
+		t.Errorf("got md5 %q, want %q", result.MD5, want)
+	}
+
+	u.PreviousMD5["GeoLite2-City"] = result.MD5
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+
+	// other editions are not checked against the city md5
+	result, err = u.ASN(context.Background(), asnFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got asn not saved")
+	}
+	if got, want := fs.filenames(), []string{asnFilename, filename}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestMD5Filename(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	dir := filepath.FromSlash("/data")
+
+	result, err := (&Updater{BaseURL: ts.URL, FileSystem: fs}).City(context.Background(), filepath.Join(dir, "city.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(fs.file(MD5Filename(dir, "GeoLite2-City"))), result.MD5; got != want {
+		t.Errorf("got md5 file %q, want %q", got, want)
+	}
+}
+
+func TestUpdater_Verify(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		SHA256:     true,
+		FileSystem: fs,
+	}
+
+	if err := u.Verify(filename, "GeoLite2-City"); !errors.Is(err, ErrNoDatabaseChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrNoDatabaseChecksum)
+	}
+
+	if _, err := u.City(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Verify(filename, "GeoLite2-City"); err != nil {
+		t.Errorf("got error %v", err)
+	}
+
+	db := fs.file(filename)
+	db[0] ^= 0xff
+	if err := writeFile(fs, filename, db, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Verify(filename, "GeoLite2-City"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got error %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	u.SHA256 = false
+	if err := u.Verify(filename, "GeoLite2-City"); !errors.Is(err, ErrNoDatabaseChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrNoDatabaseChecksum)
+	}
+}
+
+func TestUpdater_legacyMD5File(t *testing.T) {
+	ts := mmdbtest.NewServer(map[string][]byte{
+		"GeoLite2-City": mmdbtest.Database("GeoLite2-City", testBuildEpoch),
+	})
+	defer ts.Close()
+
+	fs := newMemFileSystem()
+	filename := filepath.FromSlash("/data/city.mmdb")
+	legacyFilename := filepath.FromSlash("/data/geoip_download")
+	if err := fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fs, filename, []byte("database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archiveMD5 := fmt.Sprintf("%x", md5.Sum(ts.Archive("GeoLite2-City")))
+	if err := writeFile(fs, legacyFilename, []byte(archiveMD5), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{
+		BaseURL:    ts.URL,
+		FileSystem: fs,
+	}
+	result, err := u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Saved {
+		t.Error("got saved")
+	}
+	if got := string(fs.file(MD5Filename(filepath.Dir(filename), "GeoLite2-City"))); got != archiveMD5 {
+		t.Errorf("got md5 file %q, want %q", got, archiveMD5)
+	}
+
+	if err := u.Cleanup(filename, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.file(legacyFilename); got != nil {
+		t.Errorf("got legacy md5 file %q", got)
+	}
+	if got := string(fs.file(filename)); got != "database" {
+		t.Errorf("got database %q, want %q", got, "database")
+	}
+
+	// the legacy file with the md5 sum of an other edition
+	fs = newMemFileSystem()
+	u.FileSystem = fs
+	if err := fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fs, filename, []byte("database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fs, legacyFilename, []byte("d41d8cd98f00b204e9800998ecf8427e"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the legacy file is kept without the state file of the edition
+	if err := u.Cleanup(filename, "GeoLite2-City"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.file(legacyFilename); got == nil {
+		t.Error("got legacy md5 file removed")
+	}
+	result, err = u.City(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Saved {
+		t.Error("got not saved")
+	}
+}
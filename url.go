@@ -0,0 +1,109 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UpdateFromURL downloads the archive from the URL, like a permalink or a
+// signed link of a mirror, and saves the database file with the dbname from
+// it under filename. The URL is used as provided, without edition ID,
+// license key or suffix query parameters. Credentials are sent only with
+// basic authentication if the AccountID is set. The archive format is
+// determined by the suffix query parameter or the URL path extension
+// (.tar.gz, .tgz, .tar or .mmdb), with Suffix as the fallback.
+//
+// As there is no published MD5 sum to compare with, the database is
+// downloaded and saved on every call, and no state files are written. The
+// dbname is used as the edition ID for Hooks and Metrics.
+func (u *Updater) UpdateFromURL(ctx context.Context, filename, dbname, rawURL string) (result Result, err error) {
+	defer func() { u.recordUpdate(dbname, result, err) }()
+	defer func() {
+		if err != nil {
+			err = &EditionError{EditionID: dbname, Err: err}
+		}
+	}()
+
+	suffix, err := u.urlSuffix(rawURL)
+	if err != nil {
+		return Result{}, err
+	}
+	licenseKey, err := u.licenseKey()
+	if err != nil {
+		return Result{}, err
+	}
+
+	fs := u.fileSystem()
+	if err := fs.MkdirAll(filepath.Dir(filename), u.dirMode()); err != nil {
+		return Result{}, fmt.Errorf("create directory: %w", err)
+	}
+	unlock, err := u.lock(fs, filename)
+	if err != nil {
+		return Result{}, err
+	}
+	defer unlock()
+
+	if err := probeWritable(fs, filename); err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	result, _, err = u.save(fs, filename, func(w io.Writer, preflight func(header http.Header, size int64) error) (*Metadata, int64, error) {
+		r, _, err := u.getURL(ctx, http.MethodGet, rawURL, licenseKey, dbname, time.Time{}, 0, u.Retries)
+		if err != nil {
+			return nil, 0, fmt.Errorf("get archive: %w", err)
+		}
+		return u.downloadFrom(r, w, dbname, suffix, dbname, nil, preflight)
+	}, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if !result.Saved {
+		// the database is not accepted
+		u.Hooks.skip(dbname)
+		return result, nil
+	}
+
+	u.Hooks.downloadComplete(dbname, result.Bytes, time.Since(start))
+
+	return result, nil
+}
+
+// UpdateFromURL downloads the archive from the URL and saves the database
+// file with the dbname from it under filename, on every call. The URL should
+// contain all parameters needed for the download.
+func UpdateFromURL(ctx context.Context, filename, dbname, rawURL string) (saved bool, err error) {
+	r, err := (&Updater{}).UpdateFromURL(ctx, filename, dbname, rawURL)
+	return r.Saved, err
+}
+
+// urlSuffix returns the download suffix of the archive at the URL.
+func (u *Updater) urlSuffix(rawURL string) (string, error) {
+	addr, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+	if suffix := addr.Query().Get("suffix"); suffix != "" {
+		return suffix, nil
+	}
+	switch p := strings.ToLower(addr.Path); {
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return tarGzSuffix, nil
+	case strings.HasSuffix(p, ".tar"):
+		return tarSuffix, nil
+	case strings.HasSuffix(p, ".mmdb"):
+		return mmdbSuffix, nil
+	}
+	return u.suffix(), nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2020, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found s the LICENSE file.
+
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"resenje.org/mmdb/mmdbtest"
+)
+
+func TestUpdater_UpdateFromURL(t *testing.T) {
+	db := mmdbtest.Database("GeoLite2-City", testBuildEpoch)
+	archive := testArchive(t, "GeoLite2-City_20200102/GeoLite2-City.mmdb", db)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/signed/city.tar.gz", "/permalink":
+			_, _ = w.Write(archive)
+		case "/city.mmdb":
+			_, _ = w.Write(db)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/signed/city.tar.gz?signature=test",
+		"/permalink?suffix=tar.gz",
+		"/city.mmdb",
+	} {
+		t.Run(path, func(t *testing.T) {
+			fs := newMemFileSystem()
+			filename := filepath.FromSlash("/data/city.mmdb")
+
+			result, err := (&Updater{FileSystem: fs}).UpdateFromURL(context.Background(), filename, "GeoLite2-City.mmdb", ts.URL+path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Saved {
+				t.Error("got not saved")
+			}
+			if !bytes.Equal(fs.file(filename), db) {
+				t.Error("got invalid database")
+			}
+			if got := fs.filenames(); len(got) != 1 {
+				t.Errorf("got files %q", got)
+			}
+		})
+	}
+}